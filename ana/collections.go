@@ -0,0 +1,100 @@
+package ana
+
+import (
+	"errors"
+	"fmt"
+
+	"go-hep.org/x/hep/lcio"
+)
+
+// Names of the collections written by the reconstruction chain, which the
+// tools read by default.
+const (
+	DefaultMCParticles = "MCParticle"
+	DefaultTracks      = "Tracks"
+	DefaultClusters    = "ReconClusters"
+	DefaultPFOs        = "PandoraPFOCollection"
+)
+
+// CollectionError reports a collection that is missing from an event, or is
+// not of the type expected.
+type CollectionError struct {
+	Name string
+	Want string
+	// Got is the collection found, or nil if it is missing.
+	Got interface{}
+}
+
+func (e *CollectionError) Error() string {
+	if e.Got == nil {
+		return fmt.Sprintf("collection %v is missing", e.Name)
+	}
+	return fmt.Sprintf("collection %v is %T, not %v", e.Name, e.Got, e.Want)
+}
+
+// MCParticles returns the MCParticle collection name of event.
+func MCParticles(event *lcio.Event, name string) (*lcio.McParticleContainer, error) {
+	coll, ok := event.Get(name).(*lcio.McParticleContainer)
+	if !ok || coll == nil {
+		return nil, &CollectionError{Name: name, Want: "MCParticle", Got: event.Get(name)}
+	}
+	return coll, nil
+}
+
+// Tracks returns the Track collection name of event.
+func Tracks(event *lcio.Event, name string) (*lcio.TrackContainer, error) {
+	coll, ok := event.Get(name).(*lcio.TrackContainer)
+	if !ok || coll == nil {
+		return nil, &CollectionError{Name: name, Want: "Track", Got: event.Get(name)}
+	}
+	return coll, nil
+}
+
+// Clusters returns the Cluster collection name of event.
+func Clusters(event *lcio.Event, name string) (*lcio.ClusterContainer, error) {
+	coll, ok := event.Get(name).(*lcio.ClusterContainer)
+	if !ok || coll == nil {
+		return nil, &CollectionError{Name: name, Want: "Cluster", Got: event.Get(name)}
+	}
+	return coll, nil
+}
+
+// ClusterSubDetectorNames is the parameter of a Cluster collection naming the
+// sub-detectors indexed by the SubDetEnes of its clusters.
+const ClusterSubDetectorNames = "ClusterSubdetectorNames"
+
+// ErrNoClusterSubDetectors is returned by ClusterSubDetectors for a Cluster
+// collection without the ClusterSubdetectorNames parameter, whose SubDetEnes
+// cannot be attributed to sub-detectors.
+var ErrNoClusterSubDetectors = errors.New("ana: cluster collection has no " + ClusterSubDetectorNames + " parameter")
+
+// ClusterSubDetectors returns the sub-detectors indexed by the SubDetEnes of
+// the clusters of coll, as named by its ClusterSubdetectorNames parameter.
+// The order in which a reconstruction fills SubDetEnes depends on its own
+// geometry, so without the parameter ErrNoClusterSubDetectors is returned
+// rather than guessing.
+func ClusterSubDetectors(coll *lcio.ClusterContainer) ([]SubDetector, error) {
+	names := coll.Params.Strings[ClusterSubDetectorNames]
+	if len(names) == 0 {
+		return nil, ErrNoClusterSubDetectors
+	}
+
+	subDets := make([]SubDetector, len(names))
+	for i, name := range names {
+		subDet, err := ParseSubDetector(name)
+		if err != nil {
+			return nil, fmt.Errorf("%v parameter: %v", ClusterSubDetectorNames, err)
+		}
+		subDets[i] = subDet
+	}
+	return subDets, nil
+}
+
+// PFOs returns the ReconstructedParticle collection name of event.
+func PFOs(event *lcio.Event, name string) (*lcio.RecParticleContainer, error) {
+	coll, ok := event.Get(name).(*lcio.RecParticleContainer)
+	if !ok || coll == nil {
+		return nil, &CollectionError{Name: name, Want: "ReconstructedParticle", Got: event.Get(name)}
+	}
+	return coll, nil
+}
@@ -0,0 +1,418 @@
+package ana
+
+import (
+	"encoding/xml"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultCompactDescription is the compact detector description, relative to
+// the top of the repository.
+const DefaultCompactDescription = "geom/compact_dd4hep.xml"
+
+// SubDetector is a detector of geom/compact_dd4hep.xml, numbered by its id
+// attribute, which is the system field of the cell IDs of its hits.
+type SubDetector int
+
+const (
+	SiVertexBarrel SubDetector = iota + 1
+	SiVertexEndcap
+	SiTrackerBarrel
+	SiTrackerEndcap
+	SiTrackerForward
+	EcalBarrel
+	EcalEndcap
+	HcalBarrel
+	HcalEndcap
+	MuonBarrel
+	MuonEndcap
+	LumiCal
+	BeamCal
+	NSubDetectors
+)
+
+// Calorimeters are the sub-detectors in which clusters are formed, in the
+// order they appear in the compact description.
+var Calorimeters = []SubDetector{EcalBarrel, EcalEndcap, HcalBarrel, HcalEndcap, MuonBarrel, MuonEndcap, LumiCal, BeamCal}
+
+var subDetectorNames = [NSubDetectors]string{
+	SiVertexBarrel:   "SiVertexBarrel",
+	SiVertexEndcap:   "SiVertexEndcap",
+	SiTrackerBarrel:  "SiTrackerBarrel",
+	SiTrackerEndcap:  "SiTrackerEndcap",
+	SiTrackerForward: "SiTrackerForward",
+	EcalBarrel:       "EcalBarrel",
+	EcalEndcap:       "EcalEndcap",
+	HcalBarrel:       "HcalBarrel",
+	HcalEndcap:       "HcalEndcap",
+	MuonBarrel:       "MuonBarrel",
+	MuonEndcap:       "MuonEndcap",
+	LumiCal:          "LumiCal",
+	BeamCal:          "BeamCal",
+}
+
+// ParseSubDetector returns the sub-detector of the given name, as in the
+// compact description.
+func ParseSubDetector(name string) (SubDetector, error) {
+	for d, dName := range subDetectorNames {
+		if dName != "" && strings.EqualFold(name, dName) {
+			return SubDetector(d), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown sub-detector %q", name)
+}
+
+func (d SubDetector) String() string {
+	if d <= 0 || d >= NSubDetectors {
+		return fmt.Sprintf("SubDetector(%d)", int(d))
+	}
+	return subDetectorNames[d]
+}
+
+// systemBits is the width of the system field, which every readout of the
+// compact description places first in the cell ID.
+const systemBits = 6
+
+// HitSubDetector returns the sub-detector of a hit with the given first
+// cell ID word.
+func HitSubDetector(cellID0 int32) SubDetector {
+	return SubDetector(cellID0 & (1<<systemBits - 1))
+}
+
+// Readout is a readout declared in a compact description, whose name is that
+// of the hit collection SLIC writes for its sub-detector.
+type Readout struct {
+	Name        string
+	SubDetector SubDetector
+	// Calorimeter is set for readouts of SimCalorimeterHits, and cleared for
+	// those of SimTrackerHits.
+	Calorimeter bool
+	// CellIDEncoding is the bit field description of the cell IDs.
+	CellIDEncoding string
+	// Extent is the region occupied by the sub-detector.
+	Extent Extent
+}
+
+// Extent is a region in r and z, in mm, bounding the volume of a
+// sub-detector.  It is taken from the envelopes, rings or dimensions and
+// layer thicknesses of the compact description, and is only as tight as the
+// polygons and modules of the sub-detector allow.
+type Extent struct {
+	RMin, RMax float64
+	ZMin, ZMax float64
+}
+
+// compactDetector is a detector element of a compact description, with the
+// elements that give its extent.
+type compactDetector struct {
+	ID              int    `xml:"id,attr"`
+	Name            string `xml:"name,attr"`
+	Readout         string `xml:"readout,attr"`
+	CalorimeterType string `xml:"calorimeterType,attr"`
+	Reflect         string `xml:"reflect,attr"`
+	Dimensions      *struct {
+		NumSides string `xml:"numsides,attr"`
+		RMin     string `xml:"rmin,attr"`
+		RMax     string `xml:"rmax,attr"`
+		Z        string `xml:"z,attr"`
+		ZMin     string `xml:"zmin,attr"`
+		InnerR   string `xml:"inner_r,attr"`
+		OuterR   string `xml:"outer_r,attr"`
+		InnerZ   string `xml:"inner_z,attr"`
+	} `xml:"dimensions"`
+	Modules []struct {
+		Name string `xml:"name,attr"`
+		Trd  struct {
+			X2 string `xml:"x2,attr"`
+			Z  string `xml:"z,attr"`
+		} `xml:"trd"`
+	} `xml:"module"`
+	Layers []struct {
+		Repeat   string `xml:"repeat,attr"`
+		Envelope *struct {
+			InnerR  string `xml:"inner_r,attr"`
+			OuterR  string `xml:"outer_r,attr"`
+			ZLength string `xml:"z_length,attr"`
+		} `xml:"barrel_envelope"`
+		Rings []struct {
+			R      string `xml:"r,attr"`
+			ZStart string `xml:"zstart,attr"`
+			DZ     string `xml:"dz,attr"`
+			Module string `xml:"module,attr"`
+		} `xml:"ring"`
+		Slices []struct {
+			Thickness string `xml:"thickness,attr"`
+		} `xml:"slice"`
+	} `xml:"layer"`
+}
+
+// ReadReadouts returns the readouts of the detectors of the compact
+// description at path, in the order the detectors are declared.
+func ReadReadouts(path string) ([]Readout, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var compact struct {
+		Constants []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:"value,attr"`
+		} `xml:"define>constant"`
+		Detectors []compactDetector `xml:"detectors>detector"`
+		Readouts  []struct {
+			Name string `xml:"name,attr"`
+			ID   string `xml:"id"`
+		} `xml:"readouts>readout"`
+	}
+	if err := xml.NewDecoder(f).Decode(&compact); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	// constants may refer to those defined before them
+	eval := compactEvaluator{"mm": 1, "cm": 10, "m": 1000, "pi": math.Pi}
+	for _, constant := range compact.Constants {
+		value, err := eval.eval(constant.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%v: constant %v: %v", path, constant.Name, err)
+		}
+		eval[constant.Name] = value
+	}
+
+	encodings := make(map[string]string)
+	for _, readout := range compact.Readouts {
+		encodings[readout.Name] = strings.TrimSpace(readout.ID)
+	}
+
+	var readouts []Readout
+	for _, det := range compact.Detectors {
+		if det.Readout == "" {
+			continue
+		}
+
+		encoding, ok := encodings[det.Readout]
+		if !ok {
+			return nil, fmt.Errorf("%v: readout %v is not declared", path, det.Readout)
+		}
+		extent, err := eval.extent(&det)
+		if err != nil {
+			return nil, fmt.Errorf("%v: detector %v: %v", path, det.Name, err)
+		}
+		readouts = append(readouts, Readout{
+			Name:           det.Readout,
+			SubDetector:    SubDetector(det.ID),
+			Calorimeter:    det.CalorimeterType != "",
+			CellIDEncoding: encoding,
+			Extent:         extent,
+		})
+	}
+	return readouts, nil
+}
+
+// compactEvaluator evaluates the arithmetic expressions of compact
+// description attributes, given the values of the units and constants they
+// may name.  Lengths are in mm.
+type compactEvaluator map[string]float64
+
+// eval returns the value of the expression expr, which is parsed as a Go
+// expression of numbers, names, parentheses and arithmetic operators.
+func (e compactEvaluator) eval(expr string) (float64, error) {
+	node, err := parser.ParseExpr(expr)
+	if err != nil {
+		return 0, fmt.Errorf("%q: %v", expr, err)
+	}
+	value, err := e.evalNode(node)
+	if err != nil {
+		return 0, fmt.Errorf("%q: %v", expr, err)
+	}
+	return value, nil
+}
+
+func (e compactEvaluator) evalNode(node ast.Expr) (float64, error) {
+	switch node := node.(type) {
+	case *ast.BasicLit:
+		if node.Kind != token.INT && node.Kind != token.FLOAT {
+			return 0, fmt.Errorf("%v is not a number", node.Value)
+		}
+		return strconv.ParseFloat(node.Value, 64)
+	case *ast.Ident:
+		value, ok := e[node.Name]
+		if !ok {
+			return 0, fmt.Errorf("%v is not defined", node.Name)
+		}
+		return value, nil
+	case *ast.ParenExpr:
+		return e.evalNode(node.X)
+	case *ast.UnaryExpr:
+		x, err := e.evalNode(node.X)
+		if err != nil {
+			return 0, err
+		}
+		switch node.Op {
+		case token.ADD:
+			return x, nil
+		case token.SUB:
+			return -x, nil
+		}
+	case *ast.BinaryExpr:
+		x, err := e.evalNode(node.X)
+		if err != nil {
+			return 0, err
+		}
+		y, err := e.evalNode(node.Y)
+		if err != nil {
+			return 0, err
+		}
+		switch node.Op {
+		case token.ADD:
+			return x + y, nil
+		case token.SUB:
+			return x - y, nil
+		case token.MUL:
+			return x * y, nil
+		case token.QUO:
+			return x / y, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported expression")
+}
+
+// evalAll evaluates each of exprs, stopping at the first error.
+func (e compactEvaluator) evalAll(exprs ...string) ([]float64, error) {
+	values := make([]float64, len(exprs))
+	for i, expr := range exprs {
+		value, err := e.eval(expr)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// extent returns the extent of det, which is one of the detector types of
+// geom/compact_dd4hep.xml: barrel trackers with layer envelopes, endcap
+// trackers with rings of trapezoidal modules, polyhedral barrel and endcap
+// calorimeters, and cylindrical forward calorimeters.  Endcaps are reflected
+// to negative z unless reflect is false, except for calorimeters, which are
+// only reflected if reflect is true.
+func (e compactEvaluator) extent(det *compactDetector) (Extent, error) {
+	ext := Extent{RMin: math.Inf(1), RMax: math.Inf(-1), ZMin: math.Inf(1), ZMax: math.Inf(-1)}
+	reflect := det.Reflect == "true"
+
+	if dims := det.Dimensions; dims != nil {
+		var thickness float64
+		for _, layer := range det.Layers {
+			repeat := 1.0
+			if layer.Repeat != "" {
+				var err error
+				if repeat, err = e.eval(layer.Repeat); err != nil {
+					return Extent{}, err
+				}
+			}
+			for _, slice := range layer.Slices {
+				sliceThickness, err := e.eval(slice.Thickness)
+				if err != nil {
+					return Extent{}, err
+				}
+				thickness += repeat * sliceThickness
+			}
+		}
+
+		// the outer corners of a polygon of numSides lie further out than
+		// the radius of its sides by 1/cos(pi/numSides)
+		cornerFactor := 1.0
+		if dims.NumSides != "" {
+			numSides, err := e.eval(dims.NumSides)
+			if err != nil {
+				return Extent{}, err
+			}
+			cornerFactor = 1 / math.Cos(math.Pi/numSides)
+		}
+
+		switch {
+		case dims.Z != "":
+			v, err := e.evalAll(dims.RMin, dims.Z)
+			if err != nil {
+				return Extent{}, err
+			}
+			ext = Extent{RMin: v[0], RMax: (v[0] + thickness) * cornerFactor, ZMin: -v[1] / 2, ZMax: v[1] / 2}
+		case dims.ZMin != "":
+			v, err := e.evalAll(dims.RMin, dims.RMax, dims.ZMin)
+			if err != nil {
+				return Extent{}, err
+			}
+			ext = Extent{RMin: v[0], RMax: v[1] * cornerFactor, ZMin: v[2], ZMax: v[2] + thickness}
+		default:
+			v, err := e.evalAll(dims.InnerR, dims.OuterR, dims.InnerZ)
+			if err != nil {
+				return Extent{}, err
+			}
+			ext = Extent{RMin: v[0], RMax: v[1], ZMin: v[2], ZMax: v[2] + thickness}
+		}
+	} else {
+		reflect = det.Reflect != "false"
+
+		modules := make(map[string][]float64)
+		for _, module := range det.Modules {
+			if module.Trd.Z == "" {
+				continue
+			}
+			v, err := e.evalAll(module.Trd.X2, module.Trd.Z)
+			if err != nil {
+				return Extent{}, err
+			}
+			modules[module.Name] = v
+		}
+
+		for _, layer := range det.Layers {
+			if env := layer.Envelope; env != nil {
+				v, err := e.evalAll(env.InnerR, env.OuterR, env.ZLength)
+				if err != nil {
+					return Extent{}, err
+				}
+				ext.RMin = math.Min(ext.RMin, v[0])
+				ext.RMax = math.Max(ext.RMax, v[1])
+				ext.ZMin = math.Min(ext.ZMin, -v[2]/2)
+				ext.ZMax = math.Max(ext.ZMax, v[2]/2)
+				reflect = false
+			}
+
+			for _, ring := range layer.Rings {
+				v, err := e.evalAll(ring.R, ring.ZStart, ring.DZ)
+				if err != nil {
+					return Extent{}, err
+				}
+				module, ok := modules[ring.Module]
+				if !ok {
+					return Extent{}, fmt.Errorf("ring module %v has no trd", ring.Module)
+				}
+
+				// the trapezoids extend radially by their half length z from
+				// the ring radius, and their outer corners by half width x2
+				// to either side
+				r, zStart, dz := v[0], v[1], v[2]
+				halfWidth, halfLength := module[0], module[1]
+				ext.RMin = math.Min(ext.RMin, r-halfLength)
+				ext.RMax = math.Max(ext.RMax, math.Hypot(r+halfLength, halfWidth))
+				ext.ZMin = math.Min(ext.ZMin, zStart)
+				ext.ZMax = math.Max(ext.ZMax, zStart+dz)
+			}
+		}
+		if ext.RMin > ext.RMax {
+			return Extent{}, fmt.Errorf("no layer envelopes, rings or dimensions")
+		}
+	}
+
+	if reflect {
+		ext.ZMin = -ext.ZMax
+	}
+	return ext, nil
+}
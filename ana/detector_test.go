@@ -0,0 +1,44 @@
+package ana
+
+import (
+	"math"
+	"testing"
+)
+
+// TestReadReadouts checks the extents of a few sub-detectors of the compact
+// description of the repository against its constants.
+func TestReadReadouts(t *testing.T) {
+	readouts, err := ReadReadouts("../" + DefaultCompactDescription)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extents := make(map[SubDetector]Extent)
+	for _, readout := range readouts {
+		extents[readout.SubDetector] = readout.Extent
+	}
+
+	// trackerZScale = 510 / (1684 + 510) + 1
+	trackerZScale := 510.0/2194 + 1
+	ecalThickness := 1*1 + 20*3.75 + 5*6.25
+	tests := []struct {
+		subDet SubDetector
+		want   Extent
+	}{
+		{SiVertexBarrel, Extent{RMin: 13, RMax: 63, ZMin: -63 * trackerZScale, ZMax: 63 * trackerZScale}},
+		{EcalBarrel, Extent{RMin: 1270, RMax: (1270 + ecalThickness) / math.Cos(math.Pi/12), ZMin: -2275, ZMax: 2275}},
+		{EcalEndcap, Extent{RMin: 200, RMax: 1314 / math.Cos(math.Pi/12), ZMin: -(2195 + ecalThickness), ZMax: 2195 + ecalThickness}},
+		{LumiCal, Extent{RMin: 60, RMax: 180, ZMin: -(2194 + 15*3.71 + 5*6.43), ZMax: 2194 + 15*3.71 + 5*6.43}},
+	}
+	for _, test := range tests {
+		got, ok := extents[test.subDet]
+		if !ok {
+			t.Errorf("%v: no readout", test.subDet)
+			continue
+		}
+		if math.Abs(got.RMin-test.want.RMin) > 1e-6 || math.Abs(got.RMax-test.want.RMax) > 1e-6 ||
+			math.Abs(got.ZMin-test.want.ZMin) > 1e-6 || math.Abs(got.ZMax-test.want.ZMax) > 1e-6 {
+			t.Errorf("%v: extent %+v, want %+v", test.subDet, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package ana
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"go-hep.org/x/hep/lcio"
+)
+
+// ProtonMass is in GeV.
+const ProtonMass = 0.938272
+
+// Beams holds the energies in GeV of the colliding beams.  The kinematics
+// methods take the hadron beam to travel along +z and the electron beam along
+// -z, as they do in the head-on frame.
+type Beams struct {
+	Electron float64
+	Hadron   float64
+}
+
+// S returns the square of the center-of-mass energy, neglecting the beam
+// particle masses.
+func (b Beams) S() float64 {
+	return 4 * b.Electron * b.Hadron
+}
+
+// DIS holds the invariants of a deep inelastic scattering event: Bjorken x,
+// the negative squared four-momentum transfer Q2 in GeV^2, the inelasticity
+// y and the invariant mass W of the hadronic final state in GeV.
+type DIS struct {
+	X  float64
+	Q2 float64
+	Y  float64
+	W  float64
+}
+
+// newDIS completes the invariants from Q2 and y, reporting false if they are
+// outside of the physical region.
+func newDIS(beams Beams, q2, y float64) (DIS, bool) {
+	if !(q2 > 0 && y > 0 && y < 1) {
+		return DIS{}, false
+	}
+
+	x := q2 / (beams.S() * y)
+	w2 := ProtonMass*ProtonMass + q2*(1-x)/x
+	return DIS{X: x, Q2: q2, Y: y, W: math.Sqrt(math.Max(0, w2))}, true
+}
+
+// Reasons for which TrueDIS cannot take the kinematics of an event from its
+// generator record.
+var (
+	ErrNoBeamParticles  = errors.New("no electron and hadron beam MCParticles")
+	ErrNoHardScattering = errors.New("no exchanged boson or scattered lepton from the beam electron")
+	ErrUnphysicalDIS    = errors.New("true kinematics outside of the physical region")
+)
+
+// BeamParticles returns the incoming electron and hadron of the generator
+// record, which are the particles without parents.
+func BeamParticles(particles []lcio.McParticle) (*lcio.McParticle, *lcio.McParticle, error) {
+	var electron, hadron *lcio.McParticle
+	for i := range particles {
+		particle := &particles[i]
+		if len(particle.Parents) > 0 {
+			continue
+		}
+
+		switch {
+		case particle.PDG == 11 || particle.PDG == -11:
+			electron = particle
+		case isLepton(particle.PDG):
+		case hadron == nil || particle.Energy() > hadron.Energy():
+			hadron = particle
+		}
+	}
+	if electron == nil || hadron == nil {
+		return nil, nil, ErrNoBeamParticles
+	}
+	return electron, hadron, nil
+}
+
+// TrueDIS returns the invariants of an event from the generator record,
+// given the beam particles found by BeamParticles.  The lepton four-momenta
+// are those entering and leaving the vertex of the exchanged boson, so that
+// radiation off either lepton does not enter, or those of the beam electron
+// and its scattered daughter if the record does not keep the boson.  The
+// boson itself is not used, since LCIO cannot hold the energy of a spacelike
+// particle.  Being invariants, they are the same in any frame.
+func TrueDIS(electron, hadron *lcio.McParticle) (DIS, error) {
+	incoming, outgoing := bosonVertex(electron)
+	if outgoing == nil {
+		return DIS{}, ErrNoHardScattering
+	}
+
+	k, p := newFourVec(incoming), newFourVec(hadron)
+	q := k.sub(newFourVec(outgoing))
+	q2 := -q.dot(q)
+	pq := p.dot(q)
+	y := pq / p.dot(k)
+	if !(q2 > 0 && y > 0 && y < 1) {
+		return DIS{}, ErrUnphysicalDIS
+	}
+
+	w2 := p.add(q).dot(p.add(q))
+	return DIS{X: q2 / (2 * pq), Q2: q2, Y: y, W: math.Sqrt(math.Max(0, w2))}, nil
+}
+
+// bosonVertex follows the line of leptons from the beam electron to the
+// vertex of the exchanged boson, and returns the leptons entering and leaving
+// it.  The outgoing lepton is nil if there is none.
+func bosonVertex(electron *lcio.McParticle) (*lcio.McParticle, *lcio.McParticle) {
+	for incoming := electron; incoming != nil; incoming = leptonChild(incoming) {
+		for _, child := range incoming.Children {
+			if isExchangedBoson(child) {
+				return incoming, leptonChild(incoming)
+			}
+		}
+	}
+	return electron, leptonChild(electron)
+}
+
+// leptonChild returns the lepton among the children of particle, or nil.
+func leptonChild(particle *lcio.McParticle) *lcio.McParticle {
+	for _, child := range particle.Children {
+		if isLepton(child.PDG) {
+			return child
+		}
+	}
+	return nil
+}
+
+// isExchangedBoson reports whether particle is a virtual photon, a Z or a W.
+// Photons radiated by the electron are real, and so massless.
+func isExchangedBoson(particle *lcio.McParticle) bool {
+	switch particle.PDG {
+	case 22:
+		return particle.Mass != 0
+	case 23, 24, -24:
+		return true
+	}
+	return false
+}
+
+// isLepton reports whether pdg is that of an electron or an electron
+// neutrino, the scattered leptons of neutral and charged current DIS.
+func isLepton(pdg int32) bool {
+	switch pdg {
+	case 11, -11, 12, -12:
+		return true
+	}
+	return false
+}
+
+// fourVec is a four-momentum, with the metric (+, -, -, -).
+type fourVec struct {
+	E float64
+	P Vec3
+}
+
+func newFourVec(particle *lcio.McParticle) fourVec {
+	return fourVec{E: particle.Energy(), P: Vec3(particle.P)}
+}
+
+func (v fourVec) dot(w fourVec) float64 {
+	return v.E*w.E - v.P.Dot(w.P)
+}
+
+func (v fourVec) add(w fourVec) fourVec {
+	return fourVec{E: v.E + w.E, P: Vec3{v.P[0] + w.P[0], v.P[1] + w.P[1], v.P[2] + w.P[2]}}
+}
+
+func (v fourVec) sub(w fourVec) fourVec {
+	return v.add(fourVec{E: -w.E, P: w.P.Scale(-1)})
+}
+
+// HadronicFinalState accumulates the particles of an event other than the
+// scattered electron.
+type HadronicFinalState struct {
+	// Sigma is the sum of E - p_z.
+	Sigma float64
+	PX    float64
+	PY    float64
+}
+
+// Add includes the particle with momentum p and the given energy.
+func (h *HadronicFinalState) Add(p Vec3, energy float64) {
+	h.Sigma += energy - p[2]
+	h.PX += p[0]
+	h.PY += p[1]
+}
+
+// PT returns the magnitude of the total transverse momentum.
+func (h HadronicFinalState) PT() float64 {
+	return math.Hypot(h.PX, h.PY)
+}
+
+// Gamma returns the polar angle of the struck quark inferred from the
+// hadronic final state.
+func (h HadronicFinalState) Gamma() float64 {
+	return 2 * math.Atan2(h.Sigma, h.PT())
+}
+
+// DISMethod selects how the event kinematics are reconstructed from the
+// scattered electron and the hadronic final state.
+type DISMethod int
+
+const (
+	ElectronMethod DISMethod = iota
+	JacquetBlondel
+	DoubleAngle
+	SigmaMethod
+	NDISMethods
+)
+
+var disMethodNames = [NDISMethods]string{"Electron", "Jacquet-Blondel", "Double-Angle", "Sigma"}
+
+func (m DISMethod) String() string {
+	if m < 0 || m >= NDISMethods {
+		return fmt.Sprintf("DISMethod(%d)", int(m))
+	}
+	return disMethodNames[m]
+}
+
+// Reconstruct returns the invariants of an event with a scattered electron
+// of momentum electron and energy electronEnergy, and hadronic final state
+// hfs.  It reports false if the method yields unphysical values, as the
+// hadronic methods do for events with little hadronic activity.
+func (m DISMethod) Reconstruct(beams Beams, electron Vec3, electronEnergy float64, hfs HadronicFinalState) (DIS, bool) {
+	cosTheta := electron[2] / electron.Mag()
+	sinTheta := electron.Perp() / electron.Mag()
+
+	var q2, y float64
+	switch m {
+	case ElectronMethod:
+		q2 = 2 * beams.Electron * electronEnergy * (1 + cosTheta)
+		y = 1 - electronEnergy*(1-cosTheta)/(2*beams.Electron)
+	case JacquetBlondel:
+		y = hfs.Sigma / (2 * beams.Electron)
+		q2 = hfs.PT() * hfs.PT() / (1 - y)
+	case DoubleAngle:
+		theta, gamma := math.Acos(cosTheta), hfs.Gamma()
+		denom := math.Sin(gamma) + sinTheta - math.Sin(theta+gamma)
+		if denom == 0 {
+			return DIS{}, false
+		}
+		y = sinTheta * (1 - math.Cos(gamma)) / denom
+		q2 = 4 * beams.Electron * beams.Electron * math.Sin(gamma) * (1 + cosTheta) / denom
+	case SigmaMethod:
+		y = hfs.Sigma / (hfs.Sigma + electronEnergy*(1-cosTheta))
+		electronPT := electronEnergy * sinTheta
+		q2 = electronPT * electronPT / (1 - y)
+	default:
+		panic(fmt.Sprintf("ana: unknown %v", m))
+	}
+
+	return newDIS(beams, q2, y)
+}
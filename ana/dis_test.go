@@ -0,0 +1,155 @@
+package ana
+
+import (
+	"math"
+	"testing"
+
+	"go-hep.org/x/hep/lcio"
+)
+
+// The event has 10 GeV electrons on 100 GeV protons, with the electron
+// scattered to 8 GeV at cos(theta) = -0.5, so that Q2 = 2*10*8*(1 - 0.5) = 80
+// GeV^2 and y = 1 - 8*(1 + 0.5)/20 = 0.4, and x = 80/(4000*0.4) = 0.05.  The
+// hadronic final state balancing it is a single massless particle with
+// E - p_z = 20 - 12 = 8 GeV and the opposite p_T of sqrt(48) GeV.
+var (
+	testBeams          = Beams{Electron: 10, Hadron: 100}
+	testElectron       = Vec3{math.Sqrt(48), 0, -4}
+	testElectronEnergy = 8.
+	testHadron         = Vec3{-math.Sqrt(48), 0, -1}
+	testHadronEnergy   = 7.
+)
+
+func TestReconstruct(t *testing.T) {
+	exact := DIS{X: 0.05, Q2: 80, Y: 0.4, W: math.Sqrt(ProtonMass*ProtonMass + 80*0.95/0.05)}
+
+	// With the hadronic energies scaled down by 0.8, Jacquet-Blondel gives
+	// y = 0.8*8/20 and Q2 = 0.64*48/(1 - y), and Sigma gives
+	// y = 6.4/(6.4 + 12) and Q2 = 48/(1 - y).  Double-angle depends only on
+	// angles, and the electron method not on the hadrons at all.
+	yJB, ySigma := 0.32, 6.4/18.4
+	tests := []struct {
+		method DISMethod
+		scale  float64
+		q2, y  float64
+	}{
+		{ElectronMethod, 1, exact.Q2, exact.Y},
+		{JacquetBlondel, 1, exact.Q2, exact.Y},
+		{DoubleAngle, 1, exact.Q2, exact.Y},
+		{SigmaMethod, 1, exact.Q2, exact.Y},
+		{ElectronMethod, 0.8, exact.Q2, exact.Y},
+		{JacquetBlondel, 0.8, 0.64 * 48 / (1 - yJB), yJB},
+		{DoubleAngle, 0.8, exact.Q2, exact.Y},
+		{SigmaMethod, 0.8, 48 / (1 - ySigma), ySigma},
+	}
+
+	for _, test := range tests {
+		var hfs HadronicFinalState
+		hfs.Add(testHadron.Scale(test.scale), testHadronEnergy*test.scale)
+
+		got, ok := test.method.Reconstruct(testBeams, testElectron, testElectronEnergy, hfs)
+		if !ok {
+			t.Errorf("%v with hadrons scaled by %v: unphysical", test.method, test.scale)
+			continue
+		}
+
+		x := test.q2 / (testBeams.S() * test.y)
+		if !closeTo(got.Q2, test.q2) || !closeTo(got.Y, test.y) || !closeTo(got.X, x) {
+			t.Errorf("%v with hadrons scaled by %v: got Q2 %v, y %v, x %v, want %v, %v, %v",
+				test.method, test.scale, got.Q2, got.Y, got.X, test.q2, test.y, x)
+		}
+		if test.scale == 1 && !closeTo(got.W, exact.W) {
+			t.Errorf("%v: got W %v, want %v", test.method, got.W, exact.W)
+		}
+	}
+}
+
+func TestReconstructUnphysical(t *testing.T) {
+	// without hadrons, y = 0 for the hadronic methods
+	for _, method := range []DISMethod{JacquetBlondel, DoubleAngle, SigmaMethod} {
+		if got, ok := method.Reconstruct(testBeams, testElectron, testElectronEnergy, HadronicFinalState{}); ok {
+			t.Errorf("%v without hadrons: got %+v, want unphysical", method, got)
+		}
+	}
+
+	// an electron scattered backwards with more than the beam energy gives
+	// y < 0
+	if got, ok := ElectronMethod.Reconstruct(testBeams, Vec3{1, 0, -30}, math.Sqrt(901), HadronicFinalState{}); ok {
+		t.Errorf("electron method with y < 0: got %+v, want unphysical", got)
+	}
+}
+
+// newTestRecord returns the generator record of the test event, in which the
+// electron of energy kEnergy enters the boson vertex.  If kEnergy is below the
+// beam energy, the beam electron first radiates the difference as a real
+// photon.  The scattered electron radiates a photon after the vertex, and the
+// exchanged boson is left out if withBoson is false.
+func newTestRecord(kEnergy float64, withBoson bool) []lcio.McParticle {
+	particles := make([]lcio.McParticle, 0, 8)
+	add := func(pdg int32, p Vec3, mass float64, parent *lcio.McParticle) *lcio.McParticle {
+		particles = append(particles, lcio.McParticle{PDG: pdg, P: p, Mass: mass})
+		particle := &particles[len(particles)-1]
+		if parent != nil {
+			particle.Parents = []*lcio.McParticle{parent}
+			parent.Children = append(parent.Children, particle)
+		}
+		return particle
+	}
+
+	beam := add(11, Vec3{0, 0, -testBeams.Electron}, 0, nil)
+	add(2212, Vec3{0, 0, testBeams.Hadron}, 0, nil)
+	incoming := beam
+	if kEnergy < testBeams.Electron {
+		add(22, Vec3{0, 0, kEnergy - testBeams.Electron}, 0, beam)
+		incoming = add(11, Vec3{0, 0, -kEnergy}, 0, beam)
+	}
+	if withBoson {
+		q := Vec3{-testElectron[0], 0, -kEnergy - testElectron[2]}
+		add(22, q, -1, incoming)
+	}
+	scattered := add(11, testElectron, 0, incoming)
+	add(22, Vec3{0.5, 0, 0}, 0, scattered)
+	add(11, testElectron.Scale(0.9), 0, scattered)
+	return particles
+}
+
+// With the electron entering the boson vertex at 9 GeV after radiating 1 GeV,
+// Q2 = 2*9*8*(1 - 0.5) = 72 GeV^2 and y = 1 - 8*(1 + 0.5)/18 = 1/3, and
+// x = 72/(4*9*100/3) = 0.06.  The proton is massless, so W^2 = Q2(1 - x)/x.
+func TestTrueDIS(t *testing.T) {
+	tests := []struct {
+		kEnergy   float64
+		withBoson bool
+		q2, y, x  float64
+	}{
+		{testBeams.Electron, true, 80, 0.4, 0.05},
+		{testBeams.Electron, false, 80, 0.4, 0.05},
+		{9, true, 72, 1. / 3, 0.06},
+	}
+
+	for _, test := range tests {
+		particles := newTestRecord(test.kEnergy, test.withBoson)
+		electron, hadron, err := BeamParticles(particles)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if electron != &particles[0] || hadron != &particles[1] {
+			t.Errorf("beams from %v GeV: got PDG %v and %v, want the beam particles", test.kEnergy, electron.PDG, hadron.PDG)
+		}
+
+		got, err := TrueDIS(electron, hadron)
+		if err != nil {
+			t.Errorf("%v GeV, boson %v: %v", test.kEnergy, test.withBoson, err)
+			continue
+		}
+		w := math.Sqrt(test.q2 * (1 - test.x) / test.x)
+		if !closeTo(got.Q2, test.q2) || !closeTo(got.Y, test.y) || !closeTo(got.X, test.x) || !closeTo(got.W, w) {
+			t.Errorf("%v GeV, boson %v: got %+v, want Q2 %v, y %v, x %v, W %v",
+				test.kEnergy, test.withBoson, got, test.q2, test.y, test.x, w)
+		}
+	}
+
+	if _, _, err := BeamParticles(newTestRecord(10, true)[1:2]); err != ErrNoBeamParticles {
+		t.Errorf("record without a beam electron: got error %v, want %v", err, ErrNoBeamParticles)
+	}
+}
@@ -0,0 +1,195 @@
+package ana
+
+import (
+	"fmt"
+	"math"
+
+	"go-hep.org/x/hep/hbook"
+
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// Interval selects how the confidence interval on a binomial efficiency is
+// computed.
+type Interval int
+
+const (
+	ClopperPearson Interval = iota
+	Wilson
+)
+
+// OneSigma is the confidence level of a one standard deviation interval.
+const OneSigma = 0.682689492137
+
+// ParseInterval returns the Interval named by name, either "clopper-pearson"
+// or "wilson".
+func ParseInterval(name string) (Interval, error) {
+	switch name {
+	case "clopper-pearson", "cp":
+		return ClopperPearson, nil
+	case "wilson":
+		return Wilson, nil
+	}
+	return 0, fmt.Errorf("unknown efficiency interval %q", name)
+}
+
+func (interval Interval) String() string {
+	if interval == Wilson {
+		return "wilson"
+	}
+	return "clopper-pearson"
+}
+
+// Bounds returns the lower and upper bounds of the central interval with
+// confidence level cl on the efficiency of k passing out of n trials.
+func (interval Interval) Bounds(k, n, cl float64) (float64, float64) {
+	if n <= 0 {
+		return 0, 1
+	}
+
+	alpha := (1 - cl) / 2
+	switch interval {
+	case Wilson:
+		z := distuv.UnitNormal.Quantile(1 - alpha)
+		eff := k / n
+		center := (eff + z*z/(2*n)) / (1 + z*z/n)
+		halfWidth := z / (1 + z*z/n) * math.Sqrt(eff*(1-eff)/n+z*z/(4*n*n))
+		return math.Max(0, center-halfWidth), math.Min(1, center+halfWidth)
+	default:
+		lower, upper := 0., 1.
+		if k > 0 {
+			lower = distuv.Beta{Alpha: k, Beta: n - k + 1}.Quantile(alpha)
+		}
+		if k < n {
+			upper = distuv.Beta{Alpha: k + 1, Beta: n - k}.Quantile(1 - alpha)
+		}
+		return lower, upper
+	}
+}
+
+// Efficiency returns the per-bin ratio of the weights of passHist to those of
+// totalHist, which must share the same binning, with binomial uncertainties
+// from interval at one standard deviation.  The entries of passHist must be a
+// subset of those of totalHist.  For weighted histograms, the interval is
+// that of the effective number of entries of totalHist, SumW^2/SumW2.  Bins
+// with no weight in totalHist are omitted.
+func Efficiency(passHist, totalHist *hbook.H1D, interval Interval) *hbook.S2D {
+	checkBinning(passHist, totalHist)
+
+	eff := hbook.NewS2D()
+	for i := range totalHist.Binning.Bins {
+		totalBin := &totalHist.Binning.Bins[i]
+		passBin := &passHist.Binning.Bins[i]
+
+		y, ok := binEfficiency(passBin.SumW(), totalBin.SumW())
+		if !ok {
+			continue
+		}
+		n := totalBin.SumW() * totalBin.SumW() / totalBin.SumW2()
+		lower, upper := interval.Bounds(y*n, n, OneSigma)
+		halfWidth := totalBin.XWidth() / 2
+		eff.Fill(hbook.Point2D{
+			X:    totalBin.XMid(),
+			Y:    y,
+			ErrX: hbook.Range{Min: halfWidth, Max: halfWidth},
+			ErrY: hbook.Range{Min: y - lower, Max: upper - y},
+		})
+	}
+	return eff
+}
+
+// Ratio returns the per-bin ratio of numHist to denHist, which must share the
+// same binning, with uncertainties propagated from the sums of squared
+// weights of both as if they were independent.  Use it instead of Efficiency
+// when the numerator is not a subset of the denominator, such as when
+// comparing reconstructed to true particle counts.  Bins with no weight in
+// denHist are omitted.
+func Ratio(numHist, denHist *hbook.H1D) *hbook.S2D {
+	checkBinning(numHist, denHist)
+
+	ratio := hbook.NewS2D()
+	for i := range denHist.Binning.Bins {
+		denBin := &denHist.Binning.Bins[i]
+		numBin := &numHist.Binning.Bins[i]
+
+		den := denBin.SumW()
+		if den <= 0 {
+			continue
+		}
+		num := numBin.SumW()
+
+		y := num / den
+		yErr := math.Sqrt(numBin.SumW2()/(den*den) + num*num*denBin.SumW2()/(den*den*den*den))
+		halfWidth := denBin.XWidth() / 2
+		ratio.Fill(hbook.Point2D{
+			X:    denBin.XMid(),
+			Y:    y,
+			ErrX: hbook.Range{Min: halfWidth, Max: halfWidth},
+			ErrY: hbook.Range{Min: yErr, Max: yErr},
+		})
+	}
+	return ratio
+}
+
+// binEfficiency returns the ratio of the weights pass and total, limited to
+// 1, and whether total has any weight.
+func binEfficiency(pass, total float64) (float64, bool) {
+	if total <= 0 {
+		return 0, false
+	}
+	return math.Min(pass/total, 1), true
+}
+
+func checkBinning(h1, h2 *hbook.H1D) {
+	if h1.Len() != h2.Len() || h1.XMin() != h2.XMin() || h1.XMax() != h2.XMax() {
+		panic("ana: histograms with different binning")
+	}
+}
+
+// Efficiency2D returns the per-bin ratio of the weights of passHist to those
+// of totalHist, which must share the same binning.  Bins with no weight in
+// totalHist are left empty; NewEfficiencyMap distinguishes them from bins of
+// zero efficiency.
+func Efficiency2D(passHist, totalHist *hbook.H2D) *hbook.H2D {
+	if passHist.Binning.Nx != totalHist.Binning.Nx || passHist.Binning.Ny != totalHist.Binning.Ny {
+		panic("ana: histograms with different binning")
+	}
+
+	eff := hbook.NewH2DFromEdges(binEdges(totalHist.Binning.XEdges), binEdges(totalHist.Binning.YEdges))
+	for i := range totalHist.Binning.Bins {
+		totalBin := &totalHist.Binning.Bins[i]
+		y, ok := binEfficiency(passHist.Binning.Bins[i].SumW(), totalBin.SumW())
+		if !ok {
+			continue
+		}
+		eff.Fill(totalBin.XMid(), totalBin.YMid(), y)
+	}
+	return eff
+}
+
+// LinearEdges returns the edges of n equal-width bins from min to max.
+func LinearEdges(min, max float64, n int) []float64 {
+	edges := make([]float64, n+1)
+	for i := range edges {
+		edges[i] = min + (max-min)*float64(i)/float64(n)
+	}
+	return edges
+}
+
+// LogEdges returns the edges of n bins from min to max that are of equal
+// width in log(x).  min must be positive.
+func LogEdges(min, max float64, n int) []float64 {
+	edges := LinearEdges(math.Log(min), math.Log(max), n)
+	for i, edge := range edges {
+		edges[i] = math.Exp(edge)
+	}
+	return edges
+}
+
+func binEdges(bins []hbook.Bin1D) []float64 {
+	edges := make([]float64, 0, len(bins)+1)
+	for i := range bins {
+		edges = append(edges, bins[i].XMin())
+	}
+	return append(edges, bins[len(bins)-1].XMax())
+}
@@ -0,0 +1,171 @@
+package ana
+
+import (
+	"math"
+	"testing"
+
+	"go-hep.org/x/hep/hbook"
+)
+
+// The expected Clopper-Pearson bounds are the probabilities at which the
+// binomial tails beyond k reach (1 - OneSigma) / 2, found independently of
+// the beta quantiles used by Bounds.
+func TestBounds(t *testing.T) {
+	tests := []struct {
+		interval     Interval
+		k, n         float64
+		lower, upper float64
+	}{
+		{ClopperPearson, 5, 10, 0.3048178830085556, 0.6951821169914445},
+		{ClopperPearson, 1, 4, 0.04226910630368921, 0.6184024255039202},
+		{ClopperPearson, 3, 20, 0.06951773952605131, 0.27471137336024853},
+		{ClopperPearson, 0, 10, 0, 0.16814918613795388},
+		{ClopperPearson, 10, 10, 0.8318508138620462, 1},
+		{ClopperPearson, 0, 0, 0, 1},
+		{Wilson, 5, 10, 0.34924432771111824, 0.6507556722888818},
+		{Wilson, 1, 4, 0.1, 0.5},
+		{Wilson, 0, 10, 0, 1. / 11},
+		{Wilson, 10, 10, 10. / 11, 1},
+		{Wilson, 0, 0, 0, 1},
+	}
+
+	for _, test := range tests {
+		lower, upper := test.interval.Bounds(test.k, test.n, OneSigma)
+		if !closeTo(lower, test.lower) || !closeTo(upper, test.upper) {
+			t.Errorf("%v bounds of %v/%v: got [%v, %v], want [%v, %v]",
+				test.interval, test.k, test.n, lower, upper, test.lower, test.upper)
+		}
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval Interval
+		ok       bool
+	}{
+		{"clopper-pearson", ClopperPearson, true},
+		{"cp", ClopperPearson, true},
+		{"wilson", Wilson, true},
+		{"normal", 0, false},
+	}
+
+	for _, test := range tests {
+		interval, err := ParseInterval(test.name)
+		if (err == nil) != test.ok || (test.ok && interval != test.interval) {
+			t.Errorf("ParseInterval(%q): got %v, %v", test.name, interval, err)
+		}
+		if back, err := ParseInterval(interval.String()); test.ok && (err != nil || back != interval) {
+			t.Errorf("ParseInterval(%q) does not give back %v", interval.String(), interval)
+		}
+	}
+}
+
+func TestEfficiency(t *testing.T) {
+	bins := []struct{ k, n float64 }{{2, 4}, {0, 3}, {5, 5}, {0, 0}}
+	pass := hbook.NewH1D(len(bins), 0, float64(len(bins)))
+	total := hbook.NewH1D(len(bins), 0, float64(len(bins)))
+	for i, bin := range bins {
+		for j := 0.; j < bin.n; j++ {
+			total.Fill(float64(i)+0.5, 1)
+			if j < bin.k {
+				pass.Fill(float64(i)+0.5, 1)
+			}
+		}
+	}
+
+	eff := Efficiency(pass, total, ClopperPearson)
+	if eff.Len() != 3 {
+		t.Fatalf("got %v points, want 3 with the empty bin omitted", eff.Len())
+	}
+
+	for i, pt := range eff.Points() {
+		k, n := bins[i].k, bins[i].n
+		lower, upper := ClopperPearson.Bounds(k, n, OneSigma)
+		if pt.X != float64(i)+0.5 || pt.ErrX.Min != 0.5 || pt.ErrX.Max != 0.5 {
+			t.Errorf("%v/%v: got x %v -%v +%v", k, n, pt.X, pt.ErrX.Min, pt.ErrX.Max)
+		}
+		if !closeTo(pt.Y, k/n) || !closeTo(pt.Y-pt.ErrY.Min, lower) || !closeTo(pt.Y+pt.ErrY.Max, upper) {
+			t.Errorf("%v/%v: got %v -%v +%v, want %v in [%v, %v]", k, n, pt.Y, pt.ErrY.Min, pt.ErrY.Max, k/n, lower, upper)
+		}
+	}
+
+	if pt := eff.Point(1); pt.ErrY.Min != 0 {
+		t.Errorf("zero efficiency: got lower error %v, want 0", pt.ErrY.Min)
+	}
+	if pt := eff.Point(2); pt.ErrY.Max != 0 {
+		t.Errorf("full efficiency: got upper error %v, want 0", pt.ErrY.Max)
+	}
+}
+
+// Weighted fills must give the ratio of the weights, with the interval of the
+// effective number of entries.
+func TestEfficiencyWeighted(t *testing.T) {
+	pass := hbook.NewH1D(1, 0, 1)
+	total := hbook.NewH1D(1, 0, 1)
+	total.Fill(0.5, 1)
+	total.Fill(0.5, 3)
+	pass.Fill(0.5, 3)
+
+	// 3/4, with 16/10 effective entries
+	eff := Efficiency(pass, total, Wilson)
+	lower, upper := Wilson.Bounds(0.75*1.6, 1.6, OneSigma)
+	pt := eff.Point(0)
+	if !closeTo(pt.Y, 0.75) || !closeTo(pt.Y-pt.ErrY.Min, lower) || !closeTo(pt.Y+pt.ErrY.Max, upper) {
+		t.Errorf("got %v -%v +%v, want 0.75 in [%v, %v]", pt.Y, pt.ErrY.Min, pt.ErrY.Max, lower, upper)
+	}
+
+	pass2D := hbook.NewH2DFromEdges([]float64{0, 1}, []float64{0, 1})
+	total2D := hbook.NewH2DFromEdges([]float64{0, 1}, []float64{0, 1})
+	total2D.Fill(0.5, 0.5, 1)
+	total2D.Fill(0.5, 0.5, 3)
+	pass2D.Fill(0.5, 0.5, 3)
+	if got := Efficiency2D(pass2D, total2D).Binning.Bins[0].SumW(); !closeTo(got, 0.75) {
+		t.Errorf("got 2D efficiency %v, want 0.75", got)
+	}
+}
+
+func TestRatio(t *testing.T) {
+	num := hbook.NewH1D(2, 0, 2)
+	den := hbook.NewH1D(2, 0, 2)
+	num.Fill(0.5, 2)
+	den.Fill(0.5, 2)
+	den.Fill(0.5, 2)
+
+	ratio := Ratio(num, den)
+	if ratio.Len() != 1 {
+		t.Fatalf("got %v points, want 1 with the empty bin omitted", ratio.Len())
+	}
+
+	// 2/4, with sums of squared weights 4 and 8
+	pt := ratio.Point(0)
+	wantErr := math.Sqrt(4./16 + 4*8./256)
+	if !closeTo(pt.Y, 0.5) || !closeTo(pt.ErrY.Min, wantErr) || !closeTo(pt.ErrY.Max, wantErr) {
+		t.Errorf("got %v -%v +%v, want 0.5 +- %v", pt.Y, pt.ErrY.Min, pt.ErrY.Max, wantErr)
+	}
+}
+
+func TestEfficiency2D(t *testing.T) {
+	pass := hbook.NewH2DFromEdges([]float64{0, 1, 3}, []float64{0, 1})
+	total := hbook.NewH2DFromEdges([]float64{0, 1, 3}, []float64{0, 1})
+	total.Fill(2, 0.5, 1)
+	total.Fill(2, 0.5, 1)
+	total.Fill(2, 0.5, 1)
+	total.Fill(2, 0.5, 1)
+	pass.Fill(2, 0.5, 1)
+
+	eff := Efficiency2D(pass, total)
+	if got := eff.Binning.Bins[1].SumW(); !closeTo(got, 0.25) {
+		t.Errorf("got efficiency %v, want 0.25", got)
+	}
+	if got := eff.Binning.Bins[0].Entries(); got != 0 {
+		t.Errorf("got %v entries in the bin with an empty denominator, want 0", got)
+	}
+	if got := eff.Binning.XEdges[1].Range; got != (hbook.Range{Min: 1, Max: 3}) {
+		t.Errorf("got x bin %v, want [1, 3]", got)
+	}
+}
+
+func closeTo(got, want float64) bool {
+	return math.Abs(got-want) <= 1e-9*math.Max(1, math.Abs(want))
+}
@@ -0,0 +1,463 @@
+// Package ana holds the kinematics, file-set processing and plot styling
+// shared by the analysis commands in tools.
+package ana
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-hep.org/x/hep/lcio"
+)
+
+// EventFunc analyzes a single event, passing any results to out.  It returns
+// an error, such as a *CollectionError, for an event it cannot analyze, and
+// must then not have passed any results for it.  Such events are skipped, and
+// counted by reason for each file.
+type EventFunc func(event *lcio.Event, out func(result interface{})) error
+
+// Collector accumulates the results of the events analyzed by one worker of a
+// FileSet run, typically by filling histograms.
+type Collector interface {
+	Collect(result interface{})
+	// Merge adds the results accumulated by other, which was made by the same
+	// constructor, to those of the collector.
+	Merge(other Collector)
+}
+
+// FileSet is a set of LCIO files to be analyzed concurrently.
+type FileSet struct {
+	Files []string
+
+	// NThreads is the number of workers, each reading one file at a time.
+	NThreads int
+	// MaxFiles limits the number of files processed, if positive.
+	MaxFiles int
+	// MaxFailFrac is the fraction of the files processed that may fail to be
+	// read before the run is counted as failed by ExitIfFailed.
+	MaxFailFrac float64
+}
+
+// FileError reports a file that could not be read to the end.
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Path, e.Err)
+}
+
+var (
+	// nFailedRuns counts the file-set runs of the process in which more than
+	// MaxFailFrac of the files failed.
+	nFailedRuns int
+
+	// interrupted is set once a context made by InterruptContext is canceled.
+	interrupted int32
+)
+
+// ExitIfFailed exits with status 1 if too many files failed in any file-set
+// run, or with status 130 if the process was interrupted, so that make does
+// not take partial plots for complete ones.  Commands defer it in main, so
+// that the results of the files that were read are still saved.
+func ExitIfFailed() {
+	if atomic.LoadInt32(&interrupted) != 0 {
+		os.Exit(130)
+	}
+	if nFailedRuns > 0 {
+		os.Exit(1)
+	}
+}
+
+// InterruptContext returns a context that is canceled by the first SIGINT,
+// so that commands can stop reading and still save what they have so far.
+// A second SIGINT kills the process as usual.
+func InterruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		<-sigs
+		signal.Stop(sigs)
+		log.Print("interrupted: finishing with partial results (interrupt again to quit)")
+		atomic.StoreInt32(&interrupted, 1)
+		cancel()
+	}()
+	return ctx
+}
+
+// Run calls analyze for every event in the file set and returns the results
+// collected into a collector made by newCollector.  Each of the fs.NThreads
+// workers collects into its own collector, so that they share nothing while
+// reading, and the collectors are merged once all files are read.  When there
+// are fewer files than workers, the files are split into ranges of events, so
+// that every worker is kept busy.  When ctx is canceled, the workers stop
+// after their current event and the results so far are returned.  Files that
+// fail are reported at the end of the run, and the others are analyzed
+// regardless.  Progress is reported on stderr as the files are read, and the
+// time spent on each file once they are all read.
+func (fs FileSet) Run(ctx context.Context, analyze EventFunc, newCollector func() Collector) Collector {
+	workers, _ := fs.run(ctx, analyze, newCollector, false)
+
+	collector := newCollector()
+	for _, w := range workers {
+		for _, c := range w.collectors {
+			collector.Merge(c)
+		}
+	}
+	return collector
+}
+
+// FileResult holds the results of one file of a RunFiles run.
+type FileResult struct {
+	Path string
+	// Collector holds the results of the events of the file.
+	Collector Collector
+	// NEvents is the number of events read.
+	NEvents int
+	// RunHeaders are the run headers read, by run number.  The header of a
+	// run starting at an event where a file was split among workers is
+	// missed.
+	RunHeaders map[int32]lcio.RunHeader
+	// Err is the error that stopped the file from being read to the end, if
+	// any.
+	Err error
+}
+
+// RunFiles is like Run, but collects the results of each file separately,
+// and returns them in the order of fs.Files, for commands that describe
+// files rather than fill histograms.  Files not read because ctx was canceled
+// have empty results.
+func (fs FileSet) RunFiles(ctx context.Context, analyze EventFunc, newCollector func() Collector) []FileResult {
+	workers, stats := fs.run(ctx, analyze, newCollector, true)
+
+	results := make([]FileResult, len(fs.files()))
+	index := make(map[string]int)
+	for i, inputPath := range fs.files() {
+		results[i] = FileResult{Path: inputPath, Collector: newCollector()}
+		index[inputPath] = i
+	}
+	for _, w := range workers {
+		for inputPath, c := range w.collectors {
+			results[index[inputPath]].Collector.Merge(c)
+		}
+	}
+	for inputPath, s := range stats {
+		result := &results[index[inputPath]]
+		result.NEvents = s.nEvents
+		result.RunHeaders = s.runHeaders
+		result.Err = s.err
+	}
+	return results
+}
+
+// files returns the files to be processed, at most fs.MaxFiles of fs.Files.
+func (fs FileSet) files() []string {
+	if fs.MaxFiles > 0 && fs.MaxFiles < len(fs.Files) {
+		return fs.Files[:fs.MaxFiles]
+	}
+	return fs.Files
+}
+
+// run reads the file set for Run and RunFiles, collecting the results of each
+// file separately if perFile is set, and reports skipped events and failed
+// files.  It returns the workers, and the statistics of each file read.
+func (fs FileSet) run(ctx context.Context, analyze EventFunc, newCollector func() Collector, perFile bool) ([]worker, map[string]*fileStats) {
+	files := fs.files()
+
+	nThreads := fs.NThreads
+	if nThreads < 1 {
+		nThreads = 1
+	}
+
+	ranges := splitFiles(files, nThreads)
+	if nThreads > len(ranges) {
+		nThreads = len(ranges)
+	}
+
+	queue := make(chan eventRange, len(ranges))
+	for _, r := range ranges {
+		queue <- r
+	}
+	close(queue)
+
+	p := newProgress(files, ranges, nThreads)
+	workers := make([]worker, nThreads)
+	var wg sync.WaitGroup
+	for i := range workers {
+		w := &workers[i]
+		w.newCollector = newCollector
+		w.perFile = perFile
+		w.collectors = make(map[string]Collector)
+		w.files = make(map[string]*fileStats)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.run(ctx, queue, analyze, p)
+		}()
+	}
+	wg.Wait()
+	p.finish(os.Stderr)
+
+	stats := make(map[string]*fileStats)
+	for _, w := range workers {
+		for inputPath, s := range w.files {
+			if stats[inputPath] == nil {
+				stats[inputPath] = newFileStats()
+			}
+			stats[inputPath].merge(s)
+		}
+	}
+
+	var failed []*FileError
+	for _, inputPath := range files {
+		s := stats[inputPath]
+		if s == nil {
+			continue
+		}
+		reportSkipped(inputPath, s.nEvents, s.skipped)
+		if s.err != nil {
+			failed = append(failed, &FileError{Path: inputPath, Err: s.err})
+		}
+	}
+	fs.reportFailures(failed, len(files))
+	return workers, stats
+}
+
+// eventRange is a range of the events of a file, read by a single worker.
+type eventRange struct {
+	path string
+	// start is the first event of the range, or nil to start at the beginning
+	// of the file.
+	start *indexedEvent
+	// nEvents is the number of events in the range, or 0 to read to the end
+	// of the file.
+	nEvents int
+}
+
+// splitFiles returns the ranges in which nThreads workers read files.  Files
+// are read whole when there are at least as many of them as workers, and are
+// otherwise indexed and split into ranges of equal numbers of events.  A file
+// that cannot be indexed is read whole, so that its error is reported when it
+// is read.
+func splitFiles(files []string, nThreads int) []eventRange {
+	var ranges []eventRange
+	if len(files) >= nThreads {
+		for _, inputPath := range files {
+			ranges = append(ranges, eventRange{path: inputPath})
+		}
+		return ranges
+	}
+
+	indices := make([][]indexedEvent, len(files))
+	var wg sync.WaitGroup
+	for i, inputPath := range files {
+		wg.Add(1)
+		go func(i int, inputPath string) {
+			defer wg.Done()
+			index, err := indexEvents(inputPath)
+			if err == nil {
+				indices[i] = index
+			}
+		}(i, inputPath)
+	}
+	wg.Wait()
+
+	nSplits := (nThreads + len(files) - 1) / len(files)
+	for i, inputPath := range files {
+		index := indices[i]
+		if len(index) == 0 {
+			ranges = append(ranges, eventRange{path: inputPath})
+			continue
+		}
+
+		for j := 0; j < nSplits; j++ {
+			begin, end := j*len(index)/nSplits, (j+1)*len(index)/nSplits
+			if end <= begin {
+				continue
+			}
+
+			// the first range reads from the start of the file, so that the
+			// run header before the first event is not skipped
+			r := eventRange{path: inputPath, nEvents: end - begin}
+			if begin > 0 {
+				r.start = &index[begin]
+			}
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+// fileStats counts the events of a file read by a worker, and those skipped
+// for each reason, and keeps the run headers read by run number.  err is the
+// error that stopped any of its ranges from being read to the end.
+type fileStats struct {
+	nEvents    int
+	skipped    map[string]int
+	runHeaders map[int32]lcio.RunHeader
+	err        error
+}
+
+func newFileStats() *fileStats {
+	return &fileStats{skipped: make(map[string]int), runHeaders: make(map[int32]lcio.RunHeader)}
+}
+
+func (s *fileStats) merge(other *fileStats) {
+	s.nEvents += other.nEvents
+	for reason, n := range other.skipped {
+		s.skipped[reason] += n
+	}
+	for run, header := range other.runHeaders {
+		s.runHeaders[run] = header
+	}
+	if s.err == nil {
+		s.err = other.err
+	}
+}
+
+// worker reads event ranges for a run into its own collectors.
+type worker struct {
+	newCollector func() Collector
+	// perFile is set if the results of each file are collected separately.
+	perFile bool
+	// collectors are keyed by file path if perFile is set, and otherwise
+	// hold a single collector keyed by "".
+	collectors map[string]Collector
+	files      map[string]*fileStats
+}
+
+// collector returns the collector for the results of the file at inputPath,
+// making it if need be.
+func (w *worker) collector(inputPath string) Collector {
+	if !w.perFile {
+		inputPath = ""
+	}
+	c := w.collectors[inputPath]
+	if c == nil {
+		c = w.newCollector()
+		w.collectors[inputPath] = c
+	}
+	return c
+}
+
+// run reads the ranges from queue until there are none left or ctx is
+// canceled, reporting each range done to p.
+func (w *worker) run(ctx context.Context, queue <-chan eventRange, analyze EventFunc, p *progress) {
+	for r := range queue {
+		if ctx.Err() != nil {
+			return
+		}
+
+		s := w.files[r.path]
+		if s == nil {
+			s = newFileStats()
+			w.files[r.path] = s
+		}
+		start, nEvents := time.Now(), s.nEvents
+		if err := readRange(ctx, r, analyze, w.collector(r.path).Collect, s, p); err != nil && s.err == nil {
+			s.err = err
+		}
+		p.rangeDone(r.path, s.nEvents-nEvents, time.Since(start))
+	}
+}
+
+// reportFailures logs the files of failed, out of nFiles, and counts the run
+// as failed if there are more than fs.MaxFailFrac of them.
+func (fs FileSet) reportFailures(failed []*FileError, nFiles int) {
+	if len(failed) == 0 {
+		return
+	}
+
+	log.Printf("%v of %v files failed:", len(failed), nFiles)
+	for _, fileErr := range failed {
+		log.Printf("  %v", fileErr)
+	}
+
+	if float64(len(failed)) > fs.MaxFailFrac*float64(nFiles) {
+		nFailedRuns++
+	}
+}
+
+// readRange calls analyze for each event of r until ctx is canceled, counting
+// the events in s and p, and returns any error that stopped it from reading to the
+// end, including a panic while decoding or analyzing an event.
+func readRange(ctx context.Context, r eventRange, analyze EventFunc, out func(result interface{}), s *fileStats, p *progress) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	reader, err := openEventReader(r.path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if r.start != nil {
+		if err := reader.Seek(*r.start); err != nil {
+			return err
+		}
+	}
+
+	for n := 0; r.nEvents == 0 || n < r.nEvents; n++ {
+		if ctx.Err() != nil || !reader.Next() {
+			break
+		}
+
+		event := reader.Event()
+		if err := analyze(&event, out); err != nil {
+			s.skipped[err.Error()]++
+		}
+		s.nEvents++
+		p.event()
+	}
+
+	for _, header := range reader.RunHeaders() {
+		s.runHeaders[header.RunNumber] = header
+	}
+	if err := reader.Err(); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// reportSkipped logs the number of the nEvents events of the file at
+// inputPath that were skipped for each reason.
+func reportSkipped(inputPath string, nEvents int, skipped map[string]int) {
+	reasons := make([]string, 0, len(skipped))
+	for reason := range skipped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	for _, reason := range reasons {
+		log.Printf("%v: skipped %v of %v events: %v", inputPath, skipped[reason], nEvents, reason)
+	}
+}
+
+// DirFiles returns the paths of all files in dir.
+func DirFiles(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var inputFiles []string
+	for _, file := range files {
+		inputFiles = append(inputFiles, path.Join(dir, file.Name()))
+	}
+	return inputFiles, nil
+}
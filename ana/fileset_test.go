@@ -0,0 +1,159 @@
+package ana
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"go-hep.org/x/hep/lcio"
+)
+
+// testCollector counts the results of the events analyzed.
+type testCollector struct {
+	nResults int
+}
+
+func newTestCollector() Collector {
+	return &testCollector{}
+}
+
+func (c *testCollector) Collect(result interface{}) {
+	c.nResults += result.(int)
+}
+
+func (c *testCollector) Merge(other Collector) {
+	c.nResults += other.(*testCollector).nResults
+}
+
+const (
+	nGoodFileEvents = 3
+	nBadFileEvents  = 4
+)
+
+var errTestSkip = errors.New("first event")
+
+// analyzeTestEvent skips the first event of every file, and panics on the
+// last event of the files of nBadFileEvents, which are thus the ones that
+// fail.
+func analyzeTestEvent(event *lcio.Event, out func(result interface{})) error {
+	switch event.EventNumber {
+	case 0:
+		return errTestSkip
+	case nBadFileEvents - 1:
+		panic("bad file")
+	}
+	out(1)
+	return nil
+}
+
+// The results of all workers must be merged, and the run counted as failed
+// only if more than MaxFailFrac of the files processed fail.
+func TestFileSetRun(t *testing.T) {
+	tests := []struct {
+		bad         []bool
+		nThreads    int
+		maxFiles    int
+		maxFailFrac float64
+		failed      bool
+	}{
+		{[]bool{false, false, false}, 2, 0, 0, false},
+		{[]bool{false, false, false}, 8, 0, 0, false},
+		{[]bool{false, true, false, false}, 2, 0, 0, true},
+		{[]bool{false, true, false, false}, 4, 0, 0.25, false},
+		{[]bool{true, false, true, false}, 3, 0, 0.25, true},
+		{[]bool{true, false, true, false}, 3, 0, 0.5, false},
+		{[]bool{false, false, true, true}, 2, 2, 0, false},
+		{[]bool{true}, 4, 0, 0, true},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			dir := t.TempDir()
+			var files []string
+			nResults := 0
+			for j, bad := range test.bad {
+				path := filepath.Join(dir, fmt.Sprintf("%v.slcio", j))
+				nEvents := nGoodFileEvents
+				if bad {
+					nEvents = nBadFileEvents
+				}
+				writeTestFile(t, path, nEvents, 2)
+				files = append(files, path)
+
+				if test.maxFiles == 0 || j < test.maxFiles {
+					// all but the skipped first event and the one that fails
+					nResults += nEvents - 1
+					if bad {
+						nResults--
+					}
+				}
+			}
+
+			fs := FileSet{Files: files, NThreads: test.nThreads, MaxFiles: test.maxFiles, MaxFailFrac: test.maxFailFrac}
+			nFailedBefore := nFailedRuns
+			c := fs.Run(context.Background(), analyzeTestEvent, newTestCollector).(*testCollector)
+
+			if c.nResults != nResults {
+				t.Errorf("got %v results, want %v", c.nResults, nResults)
+			}
+			if failed := nFailedRuns > nFailedBefore; failed != test.failed {
+				t.Errorf("run counted as failed: %v, want %v", failed, test.failed)
+			}
+		})
+	}
+}
+
+// A canceled run must stop without reading any events.
+func TestFileSetRunCanceled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "0.slcio")
+	writeTestFile(t, path, nGoodFileEvents, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	fs := FileSet{Files: []string{path}, NThreads: 2}
+	c := fs.Run(ctx, analyzeTestEvent, newTestCollector).(*testCollector)
+	if c.nResults != 0 {
+		t.Errorf("got %v results, want 0", c.nResults)
+	}
+}
+
+// RunFiles must keep the results, run headers and error of each file apart,
+// whether or not the files are split among workers.
+func TestFileSetRunFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{filepath.Join(dir, "0.slcio"), filepath.Join(dir, "1.slcio")}
+	writeTestFile(t, files[0], nGoodFileEvents, 2)
+	writeTestFile(t, files[1], nBadFileEvents, 2)
+
+	for _, nThreads := range []int{1, 4} {
+		t.Run(fmt.Sprint(nThreads), func(t *testing.T) {
+			fs := FileSet{Files: files, NThreads: nThreads, MaxFailFrac: 1}
+			results := fs.RunFiles(context.Background(), analyzeTestEvent, newTestCollector)
+			if len(results) != len(files) {
+				t.Fatalf("got %v results, want %v", len(results), len(files))
+			}
+
+			good, bad := results[0], results[1]
+			if good.Path != files[0] || bad.Path != files[1] {
+				t.Errorf("got paths %v and %v, want %v", good.Path, bad.Path, files)
+			}
+			if n := good.Collector.(*testCollector).nResults; n != nGoodFileEvents-1 {
+				t.Errorf("good file: got %v results, want %v", n, nGoodFileEvents-1)
+			}
+			if n := bad.Collector.(*testCollector).nResults; n != nBadFileEvents-2 {
+				t.Errorf("bad file: got %v results, want %v", n, nBadFileEvents-2)
+			}
+			if good.NEvents != nGoodFileEvents || good.Err != nil {
+				t.Errorf("good file: got %v events and error %v, want %v events", good.NEvents, good.Err, nGoodFileEvents)
+			}
+			if bad.Err == nil {
+				t.Error("bad file: no error")
+			}
+			if len(good.RunHeaders) != 2 {
+				t.Errorf("good file: got %v run headers, want 2", len(good.RunHeaders))
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package ana
+
+import (
+	"errors"
+	"math"
+
+	"go-hep.org/x/hep/fit"
+	"go-hep.org/x/hep/hbook"
+
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/optimize"
+)
+
+// GaussFit is the result of a Gaussian fit.
+type GaussFit struct {
+	Norm     float64
+	Mean     float64
+	Sigma    float64
+	MeanErr  float64
+	SigmaErr float64
+}
+
+// Gaussian returns the value of the Gaussian with parameters ps (norm, mean,
+// sigma) at x.
+func Gaussian(x float64, ps []float64) float64 {
+	pull := (x - ps[1]) / ps[2]
+	return ps[0] * math.Exp(-pull*pull/2)
+}
+
+// FitGaussianCore fits a Gaussian to the core of the distribution in h,
+// iteratively restricting the fit to bins within nSigma standard deviations
+// of the fitted mean so that non-Gaussian tails do not inflate the width.
+func FitGaussianCore(h *hbook.H1D, nSigma float64) (GaussFit, error) {
+	if h.Entries() == 0 {
+		return GaussFit{}, errors.New("ana: fit of empty histogram")
+	}
+
+	mean := h.XMean()
+	sigma := h.XStdDev()
+	norm := 0.
+	for _, bin := range h.Binning.Bins {
+		norm = math.Max(norm, bin.SumW())
+	}
+
+	var result GaussFit
+	const nIterations = 3
+	for iter := 0; iter < nIterations; iter++ {
+		f := fit.Func1D{
+			F:  Gaussian,
+			Ps: []float64{norm, mean, sigma},
+		}
+		for _, bin := range h.Binning.Bins {
+			if bin.Entries() == 0 || math.Abs(bin.XMid()-mean) > nSigma*sigma {
+				continue
+			}
+			f.X = append(f.X, bin.XMid())
+			f.Y = append(f.Y, bin.SumW())
+			f.Err = append(f.Err, bin.ErrW())
+		}
+		if len(f.X) < len(f.Ps) {
+			return GaussFit{}, errors.New("ana: too few populated bins for Gaussian fit")
+		}
+
+		res, err := fit.Curve1D(f, nil, &optimize.NelderMead{})
+		if err != nil {
+			return GaussFit{}, err
+		}
+		norm, mean, sigma = res.X[0], res.X[1], math.Abs(res.X[2])
+
+		hess := mat.NewSymDense(len(res.X), nil)
+		f.Hessian(hess, res.X)
+		var cov mat.Dense
+		if err := cov.Inverse(hess); err != nil {
+			return GaussFit{}, err
+		}
+
+		result = GaussFit{
+			Norm:     norm,
+			Mean:     mean,
+			Sigma:    sigma,
+			MeanErr:  math.Sqrt(math.Abs(cov.At(1, 1))),
+			SigmaErr: math.Sqrt(math.Abs(cov.At(2, 2))),
+		}
+	}
+
+	return result, nil
+}
+
+// CoreCurves fits the core of each of the residual histograms in hists,
+// which evenly divide the range from min to max, and returns the fitted means
+// and widths versus the bin centers.  Bins with fewer than minEntries entries
+// or a failed fit are omitted.
+func CoreCurves(hists []*hbook.H1D, min, max float64, minEntries int64, nSigma float64) (means, sigmas *hbook.S2D) {
+	means, sigmas = hbook.NewS2D(), hbook.NewS2D()
+	binWidth := (max - min) / float64(len(hists))
+	for i, h := range hists {
+		if h.Entries() < minEntries {
+			continue
+		}
+
+		gaussFit, err := FitGaussianCore(h, nSigma)
+		if err != nil {
+			continue
+		}
+
+		x := min + (float64(i)+0.5)*binWidth
+		errX := hbook.Range{Min: binWidth / 2, Max: binWidth / 2}
+		means.Fill(hbook.Point2D{
+			X:    x,
+			Y:    gaussFit.Mean,
+			ErrX: errX,
+			ErrY: hbook.Range{Min: gaussFit.MeanErr, Max: gaussFit.MeanErr},
+		})
+		sigmas.Fill(hbook.Point2D{
+			X:    x,
+			Y:    gaussFit.Sigma,
+			ErrX: errX,
+			ErrY: hbook.Range{Min: gaussFit.SigmaErr, Max: gaussFit.SigmaErr},
+		})
+	}
+	return means, sigmas
+}
+
+// ResolutionFit is the result of a fit of the calorimetric form
+// sigma/E = Stochastic/sqrt(E) (+) Constant, with E in GeV and the terms
+// added in quadrature.
+type ResolutionFit struct {
+	Stochastic    float64
+	Constant      float64
+	StochasticErr float64
+	ConstantErr   float64
+}
+
+// EnergyResolution returns the relative resolution at energy e of a
+// calorimeter with parameters ps (stochastic, constant).
+func EnergyResolution(e float64, ps []float64) float64 {
+	return math.Hypot(ps[0]/math.Sqrt(e), ps[1])
+}
+
+// FitEnergyResolution fits EnergyResolution to the relative resolutions
+// versus energy in curve.
+func FitEnergyResolution(curve *hbook.S2D) (ResolutionFit, error) {
+	f := fit.Func1D{
+		F:  EnergyResolution,
+		Ps: []float64{0.5, 0.02},
+	}
+	for _, point := range curve.Points() {
+		if point.X <= 0 {
+			continue
+		}
+		f.X = append(f.X, point.X)
+		f.Y = append(f.Y, point.Y)
+		f.Err = append(f.Err, (point.ErrY.Min+point.ErrY.Max)/2)
+	}
+	if len(f.X) < len(f.Ps) {
+		return ResolutionFit{}, errors.New("ana: too few points for resolution fit")
+	}
+
+	res, err := fit.Curve1D(f, nil, &optimize.NelderMead{})
+	if err != nil {
+		return ResolutionFit{}, err
+	}
+
+	hess := mat.NewSymDense(len(res.X), nil)
+	f.Hessian(hess, res.X)
+	var cov mat.Dense
+	if err := cov.Inverse(hess); err != nil {
+		return ResolutionFit{}, err
+	}
+
+	return ResolutionFit{
+		Stochastic:    math.Abs(res.X[0]),
+		Constant:      math.Abs(res.X[1]),
+		StochasticErr: math.Sqrt(math.Abs(cov.At(0, 0))),
+		ConstantErr:   math.Sqrt(math.Abs(cov.At(1, 1))),
+	}, nil
+}
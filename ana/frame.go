@@ -0,0 +1,171 @@
+package ana
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultCrossingAngleSource is the file the crossing angle is read from by
+// default, relative to the top of the repository.
+const DefaultCrossingAngleSource = DefaultCompactDescription
+
+// Frame is a reference frame in which to histogram kinematics.  SLIC applies
+// a Lorentz transformation along x to every generated event to model the
+// beam crossing angle, so that quantities read from its output are in the
+// lab frame.  The head-on frame undoes that transformation, restoring the
+// generator's collider frame.  The zero Frame is the lab frame.
+type Frame struct {
+	// betaGamma of the transformation, which is the tangent of the crossing
+	// angle
+	betaGamma float64
+}
+
+// LabFrame leaves kinematics as they were simulated.
+var LabFrame = Frame{}
+
+// HeadOnFrame returns the frame in which the beams collide head-on, given
+// the tangent of the crossing angle used in SLIC's Lorentz transformation.
+func HeadOnFrame(tanCrossingAngle float64) Frame {
+	return Frame{betaGamma: tanCrossingAngle}
+}
+
+func (f Frame) gamma() float64 {
+	return math.Sqrt(1 + f.betaGamma*f.betaGamma)
+}
+
+// NewFrame returns the frame named name, either "lab" or "headon".  For the
+// head-on frame, the crossing angle is read from crossingAngleSource, as
+// described by ReadCrossingAngle.
+func NewFrame(name, crossingAngleSource string) (Frame, error) {
+	switch name {
+	case "lab":
+		return LabFrame, nil
+	case "headon":
+		tanCrossingAngle, err := ReadCrossingAngle(crossingAngleSource)
+		if err != nil {
+			return Frame{}, err
+		}
+		return HeadOnFrame(tanCrossingAngle), nil
+	}
+	return Frame{}, fmt.Errorf("unknown frame %q", name)
+}
+
+// Momentum transforms the lab-frame momentum p of a particle with energy
+// energy into the frame.
+func (f Frame) Momentum(p Vec3, energy float64) Vec3 {
+	p[0] = f.gamma()*p[0] - f.betaGamma*energy
+	return p
+}
+
+// FourMomentum transforms the lab-frame four-momentum (p, energy) into the
+// frame.
+func (f Frame) FourMomentum(p Vec3, energy float64) (Vec3, float64) {
+	return f.Momentum(p, energy), f.gamma()*energy - f.betaGamma*p[0]
+}
+
+// Direction transforms the lab-frame direction u of a massless particle into
+// the frame, returning a unit vector.  It is also used for objects, such as
+// tracks and clusters, whose mass is not known.
+func (f Frame) Direction(u Vec3) Vec3 {
+	u = u.Unit()
+	return f.Momentum(u, 1).Unit()
+}
+
+// ReadCrossingAngle returns the tangent of the crossing angle defined in
+// path, which is either a compact detector description declaring the
+// tanOfCrossingAngle constant, or a SLIC macro calling
+// /generator/setLorentzTransformationAngle.
+func ReadCrossingAngle(path string) (float64, error) {
+	if filepath.Ext(path) == ".mac" {
+		return readMacroCrossingAngle(path)
+	}
+	return readCompactCrossingAngle(path)
+}
+
+func readCompactCrossingAngle(path string) (float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	decoder := xml.NewDecoder(f)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return 0, fmt.Errorf("%v: no tanOfCrossingAngle constant: %v", path, err)
+		}
+
+		elem, ok := token.(xml.StartElement)
+		if !ok || elem.Name.Local != "constant" {
+			continue
+		}
+
+		var name, value string
+		for _, attr := range elem.Attr {
+			switch attr.Name.Local {
+			case "name":
+				name = attr.Value
+			case "value":
+				value = attr.Value
+			}
+		}
+		if name != "tanOfCrossingAngle" {
+			continue
+		}
+
+		tanCrossingAngle, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return 0, fmt.Errorf("%v: tanOfCrossingAngle is not a number: %v", path, err)
+		}
+		return tanCrossingAngle, nil
+	}
+}
+
+func readMacroCrossingAngle(path string) (float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "/generator/setLorentzTransformationAngle" {
+			continue
+		}
+
+		angle, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("%v: invalid crossing angle: %v", path, err)
+		}
+
+		unit := "rad"
+		if len(fields) > 2 {
+			unit = fields[2]
+		}
+		switch unit {
+		case "rad":
+		case "mrad":
+			angle *= 1e-3
+		case "deg":
+			angle *= math.Pi / 180
+		default:
+			return 0, fmt.Errorf("%v: unknown angle unit %q", path, unit)
+		}
+
+		return math.Tan(angle), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return 0, fmt.Errorf("%v: no setLorentzTransformationAngle command", path)
+}
@@ -0,0 +1,94 @@
+package ana
+
+import (
+	"math"
+
+	"go-hep.org/x/hep/lcio"
+)
+
+// Helix holds the LCIO perigee parameters of a track: the signed distance of
+// closest approach to the reference point in r-phi, the azimuthal angle at
+// that point, the curvature signed with charge, the z of that point and the
+// tangent of the dip angle.  Lengths are in mm.
+type Helix struct {
+	D0    float64
+	Phi   float64
+	Omega float64
+	Z0    float64
+	TanL  float64
+}
+
+// HelixErrors holds the standard deviations of the Helix parameters.
+type HelixErrors Helix
+
+// cBField converts curvature in 1/mm to inverse transverse momentum in 1/GeV
+// per Tesla.
+const cBField = 0.299792458e-3
+
+// TrackHelix returns the parameters of the first track state of track, with
+// their uncertainties from the state's covariance matrix.
+func TrackHelix(track *lcio.Track) (Helix, HelixErrors) {
+	if len(track.States) == 0 {
+		return Helix{}, HelixErrors{}
+	}
+
+	state := &track.States[0]
+	helix := Helix{
+		D0:    float64(state.D0),
+		Phi:   float64(state.Phi),
+		Omega: float64(state.Omega),
+		Z0:    float64(state.Z0),
+		TanL:  float64(state.TanL),
+	}
+
+	// Cov is the lower triangle of the symmetric covariance matrix of
+	// (d0, phi, omega, z0, tanL), so the diagonal is at i*(i+3)/2.
+	errs := HelixErrors{
+		D0:    math.Sqrt(float64(state.Cov[0])),
+		Phi:   math.Sqrt(float64(state.Cov[2])),
+		Omega: math.Sqrt(float64(state.Cov[5])),
+		Z0:    math.Sqrt(float64(state.Cov[9])),
+		TanL:  math.Sqrt(float64(state.Cov[14])),
+	}
+
+	return helix, errs
+}
+
+// ParticleHelix returns the helix parameters of particle with respect to the
+// origin in a solenoidal field of bField Tesla.  The impact parameters are
+// extrapolated from the production vertex along a straight line, which is
+// accurate for particles produced within a small fraction of their radius
+// of curvature from the origin.
+func ParticleHelix(particle *lcio.McParticle, bField float64) Helix {
+	p := Vec3(particle.P)
+	vertex := particle.Vertex
+
+	pT := p.Perp()
+	phi := p.Phi()
+	tanL := p[2] / pT
+
+	omega := cBField * bField / pT
+	if particle.Charge < 0 {
+		omega = -omega
+	}
+
+	sinPhi, cosPhi := math.Sincos(phi)
+	return Helix{
+		D0:    -vertex[0]*sinPhi + vertex[1]*cosPhi,
+		Phi:   phi,
+		Omega: omega,
+		Z0:    vertex[2] - (vertex[0]*cosPhi+vertex[1]*sinPhi)*tanL,
+		TanL:  tanL,
+	}
+}
+
+// InvPT returns the inverse transverse momentum in 1/GeV of the helix in a
+// solenoidal field of bField Tesla.
+func (h Helix) InvPT(bField float64) float64 {
+	return math.Abs(h.Omega) / (cBField * bField)
+}
+
+// DeltaPhi returns phi1 - phi2 wrapped into [-pi, pi).
+func DeltaPhi(phi1, phi2 float64) float64 {
+	return math.Mod(math.Mod(phi1-phi2+math.Pi, 2*math.Pi)+2*math.Pi, 2*math.Pi) - math.Pi
+}
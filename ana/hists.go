@@ -0,0 +1,331 @@
+package ana
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go-hep.org/x/hep/hbook"
+	"go-hep.org/x/hep/hbook/yodacnv"
+)
+
+// Annotations of saved histograms, in addition to the name and title.
+// Derived objects, such as efficiencies, record the names of the histograms
+// they are derived from, so that they can be derived again once those are
+// merged.
+const (
+	XLabelKey      = "XLabel"
+	YLabelKey      = "YLabel"
+	DerivedKey     = "Derived"
+	NumeratorKey   = "Numerator"
+	DenominatorKey = "Denominator"
+	IntervalKey    = "Interval"
+)
+
+// Kinds of derived objects, as recorded under DerivedKey.
+const (
+	DerivedEfficiency = "efficiency"
+	DerivedRatio      = "ratio"
+)
+
+// Hists are the named histograms behind the plots of a command, which are
+// saved alongside them so that the plots can be drawn again, or merged with
+// those of other jobs, without reading the events again.
+type Hists struct {
+	objects []hbook.Object
+	byName  map[string]hbook.Object
+}
+
+// Add adds h under name, with the given axis labels.  Names are paths such
+// as "Track/trueEta", where the first element is the input set.
+func (hs *Hists) Add(name string, h hbook.Object, xLabel, yLabel string) {
+	ann := h.Annotation()
+	ann["name"] = name
+	if xLabel != "" {
+		ann[XLabelKey] = xLabel
+	}
+	if yLabel != "" {
+		ann[YLabelKey] = yLabel
+	}
+
+	if hs.byName == nil {
+		hs.byName = make(map[string]hbook.Object)
+	}
+	if _, ok := hs.byName[name]; ok {
+		panic(fmt.Sprintf("ana: histogram %q added twice", name))
+	}
+	hs.objects = append(hs.objects, h)
+	hs.byName[name] = h
+}
+
+// AddEfficiency adds under name the efficiency of the histograms already
+// added as passName and totalName, as computed by Efficiency for 1D
+// histograms, labelled yLabel, or by Efficiency2D for 2D histograms.
+func (hs *Hists) AddEfficiency(name, passName, totalName string, interval Interval, yLabel string) {
+	hs.addDerived(name, hbook.Annotation{
+		DerivedKey:     DerivedEfficiency,
+		NumeratorKey:   passName,
+		DenominatorKey: totalName,
+		IntervalKey:    interval.String(),
+	}, yLabel)
+}
+
+// AddRatio adds under name the ratio of the 1D histograms already added as
+// numName and denName, as computed by Ratio, labelled yLabel.
+func (hs *Hists) AddRatio(name, numName, denName, yLabel string) {
+	hs.addDerived(name, hbook.Annotation{
+		DerivedKey:     DerivedRatio,
+		NumeratorKey:   numName,
+		DenominatorKey: denName,
+	}, yLabel)
+}
+
+func (hs *Hists) addDerived(name string, derivation hbook.Annotation, yLabel string) {
+	h, err := Derive(derivation, func(name string) hbook.Object { return hs.byName[name] })
+	if err != nil {
+		panic(err)
+	}
+
+	for key, value := range derivation {
+		h.Annotation()[key] = value
+	}
+	total := hs.byName[annString(derivation, DenominatorKey)].Annotation()
+	xLabel := annString(total, XLabelKey)
+	if _, ok := h.(*hbook.H2D); ok {
+		yLabel = annString(total, YLabelKey)
+	}
+	hs.Add(name, h, xLabel, yLabel)
+}
+
+// Save writes the histograms as YODA to HistsPath(outputPath).
+func (hs *Hists) Save(outputPath string) error {
+	return WriteHists(HistsPath(outputPath), hs.objects)
+}
+
+// WriteHists writes objects as YODA to the file at path.
+func WriteHists(path string, objects []hbook.Object) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	if err := WriteYODA(w, objects...); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// HistsPath returns the path of the histograms saved alongside the plots
+// written to outputPath: the same path with a .yoda extension.
+func HistsPath(outputPath string) string {
+	return strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".yoda"
+}
+
+// ReadHists returns the histograms and scatters of the YODA file at path.
+func ReadHists(path string) ([]hbook.Object, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	objects, err := yodacnv.Read(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	for _, object := range objects {
+		if h, ok := object.(*hbook.H2D); ok {
+			fixEdges(h)
+		}
+	}
+	return objects, nil
+}
+
+// fixEdges sets the bin edges of h, read from YODA, from its bins.  hbook
+// takes the bins read to be of equal width, which leaves the edges of
+// variable-width bins wrong, and so the bins that Fill finds.
+func fixEdges(h *hbook.H2D) {
+	b := &h.Binning
+	for ix := range b.XEdges {
+		b.XEdges[ix].Range = b.Bins[ix].XRange
+	}
+	for iy := range b.YEdges {
+		b.YEdges[iy].Range = b.Bins[iy*b.Nx].YRange
+	}
+}
+
+// Derive returns the object described by the DerivedKey, NumeratorKey,
+// DenominatorKey and IntervalKey annotations of derivation, from the
+// histograms that lookup returns by name.
+func Derive(derivation hbook.Annotation, lookup func(name string) hbook.Object) (hbook.Object, error) {
+	kind := annString(derivation, DerivedKey)
+	numName := annString(derivation, NumeratorKey)
+	denName := annString(derivation, DenominatorKey)
+
+	num, den := lookup(numName), lookup(denName)
+	if num == nil || den == nil {
+		return nil, fmt.Errorf("%v of %q and %q: histogram not found", kind, numName, denName)
+	}
+
+	switch kind {
+	case DerivedEfficiency:
+		interval, err := ParseInterval(annString(derivation, IntervalKey))
+		if err != nil {
+			return nil, err
+		}
+
+		switch num := num.(type) {
+		case *hbook.H1D:
+			if den, ok := den.(*hbook.H1D); ok {
+				return Efficiency(num, den, interval), nil
+			}
+		case *hbook.H2D:
+			if den, ok := den.(*hbook.H2D); ok {
+				return Efficiency2D(num, den), nil
+			}
+		}
+	case DerivedRatio:
+		num, numOK := num.(*hbook.H1D)
+		den, denOK := den.(*hbook.H1D)
+		if numOK && denOK {
+			return Ratio(num, den), nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown derived object %q", kind)
+	}
+	return nil, fmt.Errorf("%v of %q and %q: histograms of different kinds", kind, numName, denName)
+}
+
+// annString returns the annotation key of ann as a string, which it may not
+// be once read back from YAML, or "" if it is missing.
+func annString(ann hbook.Annotation, key string) string {
+	value, ok := ann[key]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// WriteYODA writes objects, which must be *hbook.H1D, *hbook.H2D or
+// *hbook.S2D, to w in the YODA format read by yodacnv.  hbook's own writer
+// rounds to 7 significant digits, which loses the bin edges and weight sums
+// of large samples, so every value is written exactly instead.  As with
+// hbook, the outflows of 2D histograms are not written.
+func WriteYODA(w io.Writer, objects ...hbook.Object) error {
+	for _, object := range objects {
+		var err error
+		switch object := object.(type) {
+		case *hbook.H1D:
+			err = writeYODAH1D(w, object)
+		case *hbook.H2D:
+			err = writeYODAH2D(w, object)
+		case *hbook.S2D:
+			err = writeYODAS2D(w, object)
+		default:
+			err = fmt.Errorf("ana: cannot write %T as YODA", object)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeYODAHeader writes the BEGIN line and annotations of object, of the
+// YODA type typeName.
+func writeYODAHeader(w io.Writer, object hbook.Object, typeName string) error {
+	ann := hbook.Annotation{
+		"Type":  typeName,
+		"Path":  "/" + object.Name(),
+		"Title": "",
+	}
+	for key, value := range object.Annotation() {
+		switch key {
+		case "name":
+		case "title":
+			ann["Title"] = value
+		default:
+			ann[key] = value
+		}
+	}
+
+	data, err := ann.MarshalYODA()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "BEGIN YODA_%v_V2 %v\n", strings.ToUpper(typeName), ann["Path"])
+	w.Write(data)
+	_, err = fmt.Fprintf(w, "---\n")
+	return err
+}
+
+func writeYODAH1D(w io.Writer, h *hbook.H1D) error {
+	if err := writeYODAHeader(w, h, "Histo1D"); err != nil {
+		return err
+	}
+
+	dist := func(d hbook.Dist1D) string {
+		return fmt.Sprintf("%v\t%v\t%v\t%v\t%v", d.SumW(), d.SumW2(), d.SumWX(), d.SumWX2(), d.Entries())
+	}
+	fmt.Fprintf(w, "# Mean: %v\n", h.XMean())
+	fmt.Fprintf(w, "# Area: %v\n", h.Integral())
+	fmt.Fprintf(w, "# ID\t ID\t sumw\t sumw2\t sumwx\t sumwx2\t numEntries\n")
+	fmt.Fprintf(w, "Total   \tTotal   \t%v\n", dist(h.Binning.Dist))
+	fmt.Fprintf(w, "Underflow\tUnderflow\t%v\n", dist(h.Binning.Outflows[0]))
+	fmt.Fprintf(w, "Overflow\tOverflow\t%v\n", dist(h.Binning.Outflows[1]))
+	fmt.Fprintf(w, "# xlow\t xhigh\t sumw\t sumw2\t sumwx\t sumwx2\t numEntries\n")
+	for _, bin := range h.Binning.Bins {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", bin.Range.Min, bin.Range.Max, dist(bin.Dist))
+	}
+	_, err := fmt.Fprintf(w, "END YODA_HISTO1D_V2\n\n")
+	return err
+}
+
+func writeYODAH2D(w io.Writer, h *hbook.H2D) error {
+	if err := writeYODAHeader(w, h, "Histo2D"); err != nil {
+		return err
+	}
+
+	dist := func(d hbook.Dist2D) string {
+		return fmt.Sprintf("%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v",
+			d.SumW(), d.SumW2(), d.SumWX(), d.SumWX2(), d.SumWY(), d.SumWY2(), d.SumWXY(), d.Entries())
+	}
+	fmt.Fprintf(w, "# Mean: (%v, %v)\n", h.XMean(), h.YMean())
+	fmt.Fprintf(w, "# Volume: %v\n", h.Integral())
+	fmt.Fprintf(w, "# ID\t ID\t sumw\t sumw2\t sumwx\t sumwx2\t sumwy\t sumwy2\t sumwxy\t numEntries\n")
+	fmt.Fprintf(w, "Total   \tTotal   \t%v\n", dist(h.Binning.Dist))
+	fmt.Fprintf(w, "# 2D outflow persistency not currently supported until API is stable\n")
+	fmt.Fprintf(w, "# xlow\t xhigh\t ylow\t yhigh\t sumw\t sumw2\t sumwx\t sumwx2\t sumwy\t sumwy2\t sumwxy\t numEntries\n")
+	for ix := 0; ix < h.Binning.Nx; ix++ {
+		for iy := 0; iy < h.Binning.Ny; iy++ {
+			bin := &h.Binning.Bins[iy*h.Binning.Nx+ix]
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n",
+				bin.XRange.Min, bin.XRange.Max, bin.YRange.Min, bin.YRange.Max, dist(bin.Dist))
+		}
+	}
+	_, err := fmt.Fprintf(w, "END YODA_HISTO2D_V2\n\n")
+	return err
+}
+
+func writeYODAS2D(w io.Writer, s *hbook.S2D) error {
+	if err := writeYODAHeader(w, s, "Scatter2D"); err != nil {
+		return err
+	}
+
+	s.Sort()
+	fmt.Fprintf(w, "# xval\t xerr-\t xerr+\t yval\t yerr-\t yerr+\t\n")
+	for _, pt := range s.Points() {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", pt.X, pt.ErrX.Min, pt.ErrX.Max, pt.Y, pt.ErrY.Min, pt.ErrY.Max)
+	}
+	_, err := fmt.Fprintf(w, "END YODA_SCATTER2D_V2\n\n")
+	return err
+}
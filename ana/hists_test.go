@@ -0,0 +1,193 @@
+package ana
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go-hep.org/x/hep/hbook"
+)
+
+// testEntry is a weighted entry of the test histograms, which also enters
+// their numerators if pass is set.
+type testEntry struct {
+	x, y, w float64
+	pass    bool
+}
+
+var testEntries = []testEntry{
+	{0.5, 0.5, 1, true},
+	{0.5, 2.5, 2, false},
+	{1.5, 0.2, 1, true},
+	{1.5, 4, 0.5, true},
+	{2.5, 1.5, 1, false},
+	{3.5, 3, 3, true},
+	{-1, 1, 1, true},
+	{0.2, 5.5, 1, false},
+	{2.2, 0.7, 2, true},
+	{2.8, 2.2, 1, false},
+	{3.1, 0.1, 1.5, false},
+	{1.1, 3.3, 1, true},
+}
+
+// fillTestHists returns histograms filled with entries, as saved by a job, in
+// which the 2D ones have bins of variable width.
+func fillTestHists(entries []testEntry) *Hists {
+	total := hbook.NewH1D(4, 0, 4)
+	pass := hbook.NewH1D(4, 0, 4)
+	mapTotal := hbook.NewH2DFromEdges([]float64{0, 1, 3, 4}, []float64{0, 0.5, 2, 6})
+	mapPass := hbook.NewH2DFromEdges([]float64{0, 1, 3, 4}, []float64{0, 0.5, 2, 6})
+	for _, e := range entries {
+		total.Fill(e.x, e.w)
+		mapTotal.Fill(e.x, e.y, e.w)
+		if e.pass {
+			pass.Fill(e.x, e.w)
+			mapPass.Fill(e.x, e.y, e.w)
+		}
+	}
+
+	var hs Hists
+	hs.Add("set/total", total, "x", "count")
+	hs.Add("set/pass", pass, "x", "count")
+	hs.Add("set/mapTotal", mapTotal, "x", "y")
+	hs.Add("set/mapPass", mapPass, "x", "y")
+	hs.AddEfficiency("set/eff", "set/pass", "set/total", Wilson, "efficiency")
+	hs.AddEfficiency("set/mapEff", "set/mapPass", "set/mapTotal", ClopperPearson, "")
+	hs.AddRatio("set/ratio", "set/pass", "set/total", "ratio")
+	return &hs
+}
+
+// The histograms of two jobs, written, read back and merged, must be those
+// filled directly by a single job, with their efficiencies and ratios.
+func TestMergeHists(t *testing.T) {
+	dir := t.TempDir()
+	half := len(testEntries) / 2
+	var paths []string
+	for i, entries := range [][]testEntry{testEntries[:half], testEntries[half:]} {
+		path := filepath.Join(dir, []string{"a.pdf", "b.pdf"}[i])
+		if err := fillTestHists(entries).Save(path); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, HistsPath(path))
+	}
+
+	merged, err := ReadHists(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ReadHists(paths[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := MergeHists(merged, other); err != nil {
+		t.Fatal(err)
+	}
+	if err := Rederive(merged); err != nil {
+		t.Fatal(err)
+	}
+
+	direct := fillTestHists(testEntries)
+	if len(merged) != len(direct.objects) {
+		t.Fatalf("got %v objects, want %v", len(merged), len(direct.objects))
+	}
+	for _, got := range merged {
+		want := direct.byName[got.Name()]
+		if want == nil {
+			t.Errorf("%q: not saved", got.Name())
+			continue
+		}
+		compareObjects(t, got, want)
+	}
+}
+
+func compareObjects(t *testing.T, got, want hbook.Object) {
+	t.Helper()
+	name := want.Name()
+	switch want := want.(type) {
+	case *hbook.H1D:
+		got, ok := got.(*hbook.H1D)
+		if !ok {
+			t.Errorf("%q: got %T, want *hbook.H1D", name, got)
+			return
+		}
+		if len(got.Binning.Bins) != len(want.Binning.Bins) {
+			t.Errorf("%q: got %v bins, want %v", name, len(got.Binning.Bins), len(want.Binning.Bins))
+			return
+		}
+		for i, wantBin := range want.Binning.Bins {
+			gotBin := got.Binning.Bins[i]
+			if gotBin.Range != wantBin.Range || !closeTo(gotBin.SumW(), wantBin.SumW()) || !closeTo(gotBin.SumW2(), wantBin.SumW2()) {
+				t.Errorf("%q bin %v: got %v with sumw %v, sumw2 %v, want %v with %v, %v", name, i,
+					gotBin.Range, gotBin.SumW(), gotBin.SumW2(), wantBin.Range, wantBin.SumW(), wantBin.SumW2())
+			}
+		}
+		for i, wantOut := range want.Binning.Outflows {
+			if gotOut := got.Binning.Outflows[i]; !closeTo(gotOut.SumW(), wantOut.SumW()) {
+				t.Errorf("%q outflow %v: got sumw %v, want %v", name, i, gotOut.SumW(), wantOut.SumW())
+			}
+		}
+	case *hbook.H2D:
+		got, ok := got.(*hbook.H2D)
+		if !ok {
+			t.Errorf("%q: got %T, want *hbook.H2D", name, got)
+			return
+		}
+		if got.Binning.Nx != want.Binning.Nx || got.Binning.Ny != want.Binning.Ny {
+			t.Errorf("%q: got %vx%v bins, want %vx%v", name, got.Binning.Nx, got.Binning.Ny, want.Binning.Nx, want.Binning.Ny)
+			return
+		}
+		for i, wantEdge := range want.Binning.XEdges {
+			if gotEdge := got.Binning.XEdges[i]; gotEdge.Range != wantEdge.Range {
+				t.Errorf("%q x edge %v: got %v, want %v", name, i, gotEdge.Range, wantEdge.Range)
+			}
+		}
+		for i, wantEdge := range want.Binning.YEdges {
+			if gotEdge := got.Binning.YEdges[i]; gotEdge.Range != wantEdge.Range {
+				t.Errorf("%q y edge %v: got %v, want %v", name, i, gotEdge.Range, wantEdge.Range)
+			}
+		}
+		for i, wantBin := range want.Binning.Bins {
+			gotBin := got.Binning.Bins[i]
+			if gotBin.XRange != wantBin.XRange || gotBin.YRange != wantBin.YRange ||
+				!closeTo(gotBin.SumW(), wantBin.SumW()) || !closeTo(gotBin.SumW2(), wantBin.SumW2()) {
+				t.Errorf("%q bin %v: got %v x %v with sumw %v, sumw2 %v, want %v x %v with %v, %v", name, i,
+					gotBin.XRange, gotBin.YRange, gotBin.SumW(), gotBin.SumW2(),
+					wantBin.XRange, wantBin.YRange, wantBin.SumW(), wantBin.SumW2())
+			}
+		}
+	case *hbook.S2D:
+		got, ok := got.(*hbook.S2D)
+		if !ok {
+			t.Errorf("%q: got %T, want *hbook.S2D", name, got)
+			return
+		}
+		if got.Len() != want.Len() {
+			t.Errorf("%q: got %v points, want %v", name, got.Len(), want.Len())
+			return
+		}
+		for i, wantPt := range want.Points() {
+			gotPt := got.Point(i)
+			if !closeTo(gotPt.X, wantPt.X) || !closeTo(gotPt.Y, wantPt.Y) ||
+				!closeTo(gotPt.ErrY.Min, wantPt.ErrY.Min) || !closeTo(gotPt.ErrY.Max, wantPt.ErrY.Max) {
+				t.Errorf("%q point %v: got %+v, want %+v", name, i, gotPt, wantPt)
+			}
+		}
+	}
+}
+
+func TestMergeHistsBinning(t *testing.T) {
+	h1 := hbook.NewH1D(4, 0, 4)
+	h1.Annotation()["name"] = "set/total"
+	h2 := hbook.NewH1D(4, 0, 8)
+	h2.Annotation()["name"] = "set/total"
+	if err := MergeHists([]hbook.Object{h1}, []hbook.Object{h2}); err == nil {
+		t.Error("1D histograms with different binning merged")
+	}
+
+	m1 := hbook.NewH2DFromEdges([]float64{0, 1, 3}, []float64{0, 1})
+	m1.Annotation()["name"] = "set/map"
+	m2 := hbook.NewH2DFromEdges([]float64{0, 2, 3}, []float64{0, 1})
+	m2.Annotation()["name"] = "set/map"
+	if err := MergeHists([]hbook.Object{m1}, []hbook.Object{m2}); err == nil {
+		t.Error("2D histograms with different bin edges merged")
+	}
+}
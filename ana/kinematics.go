@@ -0,0 +1,77 @@
+package ana
+
+import (
+	"math"
+)
+
+// Vec3 is a Cartesian three-vector, used for both momenta and positions.
+type Vec3 [3]float64
+
+// Vec3From32 converts the single-precision vectors stored in many LCIO types
+// (cluster positions, PFO momenta) to a Vec3.
+func Vec3From32(vector [3]float32) Vec3 {
+	return Vec3{float64(vector[0]), float64(vector[1]), float64(vector[2])}
+}
+
+// TrackDirection returns the unit direction of a helix at its reference point,
+// given the tangent of its dip angle and its azimuthal angle.
+func TrackDirection(tanLambda, phi float64) Vec3 {
+	lambda := math.Atan(tanLambda)
+	return Vec3{
+		math.Cos(phi) * math.Cos(lambda),
+		math.Sin(phi) * math.Cos(lambda),
+		math.Sin(lambda),
+	}
+}
+
+func (v Vec3) Dot(w Vec3) float64 {
+	return v[0]*w[0] + v[1]*w[1] + v[2]*w[2]
+}
+
+// Scale returns v multiplied by factor.
+func (v Vec3) Scale(factor float64) Vec3 {
+	for i, value := range v {
+		v[i] = value * factor
+	}
+	return v
+}
+
+func (v Vec3) Mag() float64 {
+	return math.Sqrt(v.Dot(v))
+}
+
+// Unit returns v normalized to unit length.
+func (v Vec3) Unit() Vec3 {
+	normFactor := v.Mag()
+	for i, value := range v {
+		v[i] = value / normFactor
+	}
+	return v
+}
+
+// Perp returns the magnitude of the component transverse to the beam (z) axis.
+func (v Vec3) Perp() float64 {
+	return math.Sqrt(v[0]*v[0] + v[1]*v[1])
+}
+
+// Eta returns the pseudorapidity of v.
+func (v Vec3) Eta() float64 {
+	return math.Atanh(v[2] / v.Mag())
+}
+
+// Phi returns the azimuthal angle of v in (-pi, pi].
+func (v Vec3) Phi() float64 {
+	return math.Atan2(v[1], v[0])
+}
+
+// Angle returns the opening angle between v and w.
+func (v Vec3) Angle(w Vec3) float64 {
+	cosAngle := v.Dot(w) / (v.Mag() * w.Mag())
+	return math.Acos(math.Max(-1, math.Min(1, cosAngle)))
+}
+
+// TrackPT returns the transverse momentum in GeV of a helix with curvature
+// omega (in 1/mm) in a solenoidal field of bField Tesla.
+func TrackPT(omega, bField float64) float64 {
+	return cBField * bField / math.Abs(omega)
+}
@@ -0,0 +1,168 @@
+package ana
+
+import (
+	"fmt"
+
+	"go-hep.org/x/hep/hbook"
+)
+
+// MergeH1D adds the entries of src to dst in place.  hbook.AddH1D returns a
+// new histogram instead, which is wasteful when merging the histograms of
+// many workers.  The histograms must share the same binning.
+func MergeH1D(dst, src *hbook.H1D) {
+	checkBinning(dst, src)
+
+	for i := range dst.Binning.Bins {
+		mergeDist1D(&dst.Binning.Bins[i].Dist, &src.Binning.Bins[i].Dist)
+	}
+	mergeDist1D(&dst.Binning.Dist, &src.Binning.Dist)
+	for i := range dst.Binning.Outflows {
+		mergeDist1D(&dst.Binning.Outflows[i], &src.Binning.Outflows[i])
+	}
+}
+
+// MergeH1Ds calls MergeH1D for each histogram of dst and the corresponding
+// one of src.
+func MergeH1Ds(dst, src []*hbook.H1D) {
+	if len(dst) != len(src) {
+		panic("ana: merging different numbers of histograms")
+	}
+	for i := range dst {
+		MergeH1D(dst[i], src[i])
+	}
+}
+
+// MergeH2D adds the entries of src to dst in place.  The histograms must
+// share the same binning.
+func MergeH2D(dst, src *hbook.H2D) {
+	if dst.Binning.Nx != src.Binning.Nx || dst.Binning.Ny != src.Binning.Ny ||
+		dst.Binning.XRange != src.Binning.XRange || dst.Binning.YRange != src.Binning.YRange {
+		panic("ana: histograms with different binning")
+	}
+
+	for i := range dst.Binning.Bins {
+		mergeDist2D(&dst.Binning.Bins[i].Dist, &src.Binning.Bins[i].Dist)
+	}
+	mergeDist2D(&dst.Binning.Dist, &src.Binning.Dist)
+	for i := range dst.Binning.Outflows {
+		mergeDist2D(&dst.Binning.Outflows[i], &src.Binning.Outflows[i])
+	}
+}
+
+func mergeDist0D(dst, src *hbook.Dist0D) {
+	dst.N += src.N
+	dst.SumW += src.SumW
+	dst.SumW2 += src.SumW2
+}
+
+func mergeDist1D(dst, src *hbook.Dist1D) {
+	mergeDist0D(&dst.Dist, &src.Dist)
+	dst.Stats.SumWX += src.Stats.SumWX
+	dst.Stats.SumWX2 += src.Stats.SumWX2
+}
+
+func mergeDist2D(dst, src *hbook.Dist2D) {
+	mergeDist1D(&dst.X, &src.X)
+	mergeDist1D(&dst.Y, &src.Y)
+	dst.Stats.SumWXY += src.Stats.SumWXY
+}
+
+// MergeHists adds the histograms of src to those of the same name in dst,
+// both as read by ReadHists from the outputs of separate jobs.  Efficiencies
+// and ratios are not merged, and must be derived again with Rederive once all
+// jobs are merged.  An error is returned, with dst partly merged, if the two
+// do not hold histograms of the same names and binnings.
+func MergeHists(dst, src []hbook.Object) error {
+	if len(dst) != len(src) {
+		return fmt.Errorf("different numbers of histograms: %v and %v", len(dst), len(src))
+	}
+	byName := make(map[string]hbook.Object, len(src))
+	for _, object := range src {
+		byName[object.Name()] = object
+	}
+
+	for _, object := range dst {
+		if annString(object.Annotation(), DerivedKey) != "" {
+			continue
+		}
+
+		name := object.Name()
+		switch dst := object.(type) {
+		case *hbook.H1D:
+			src, ok := byName[name].(*hbook.H1D)
+			if !ok {
+				return fmt.Errorf("%q: no 1D histogram of that name to merge", name)
+			}
+			if !sameBinning1D(dst, src) {
+				return fmt.Errorf("%q: histograms with different binning", name)
+			}
+			MergeH1D(dst, src)
+		case *hbook.H2D:
+			src, ok := byName[name].(*hbook.H2D)
+			if !ok {
+				return fmt.Errorf("%q: no 2D histogram of that name to merge", name)
+			}
+			if !sameBinning2D(dst, src) {
+				return fmt.Errorf("%q: histograms with different binning", name)
+			}
+			MergeH2D(dst, src)
+		default:
+			return fmt.Errorf("%q: cannot merge %T", name, object)
+		}
+	}
+	return nil
+}
+
+// Rederive replaces each efficiency and ratio of objects by one derived
+// again from the histograms of objects it was derived from, keeping its
+// name, labels and other annotations.
+func Rederive(objects []hbook.Object) error {
+	byName := make(map[string]hbook.Object, len(objects))
+	for _, object := range objects {
+		byName[object.Name()] = object
+	}
+
+	for i, object := range objects {
+		ann := object.Annotation()
+		if annString(ann, DerivedKey) == "" {
+			continue
+		}
+
+		derived, err := Derive(ann, func(name string) hbook.Object { return byName[name] })
+		if err != nil {
+			return fmt.Errorf("%q: %v", object.Name(), err)
+		}
+		for key, value := range ann {
+			derived.Annotation()[key] = value
+		}
+		objects[i] = derived
+	}
+	return nil
+}
+
+// sameBinning1D returns whether h1 and h2 have the same bins, which
+// checkBinning only checks by their number and range.
+func sameBinning1D(h1, h2 *hbook.H1D) bool {
+	if len(h1.Binning.Bins) != len(h2.Binning.Bins) {
+		return false
+	}
+	for i := range h1.Binning.Bins {
+		if h1.Binning.Bins[i].Range != h2.Binning.Bins[i].Range {
+			return false
+		}
+	}
+	return true
+}
+
+func sameBinning2D(h1, h2 *hbook.H2D) bool {
+	if h1.Binning.Nx != h2.Binning.Nx || h1.Binning.Ny != h2.Binning.Ny {
+		return false
+	}
+	for i := range h1.Binning.Bins {
+		b1, b2 := &h1.Binning.Bins[i], &h2.Binning.Bins[i]
+		if b1.XRange != b2.XRange || b1.YRange != b2.YRange {
+			return false
+		}
+	}
+	return true
+}
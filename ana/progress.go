@@ -0,0 +1,186 @@
+package ana
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// statusInterval is how often the status line is redrawn on a terminal.
+const statusInterval = 250 * time.Millisecond
+
+// progress reports the progress of a file-set run on stderr.  A line is
+// logged as each file is done, with the throughput so far and the time left,
+// and on a terminal a status line below them is kept up to date as well.
+// Once the run is over, the time spent on each file is listed, so that the
+// number of workers and the time asked of a batch system can be chosen from
+// it.
+type progress struct {
+	// nEvents is updated atomically by the workers for every event read.
+	nEvents int64
+
+	start    time.Time
+	nThreads int
+	nRanges  int
+	tty      bool
+
+	mu         sync.Mutex
+	files      []string
+	timings    map[string]*fileTiming
+	nDone      int
+	rangesDone int
+
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// fileTiming is the time spent by the workers on the ranges of a file.
+type fileTiming struct {
+	rangesLeft int
+	nEvents    int
+	elapsed    time.Duration
+}
+
+func newProgress(files []string, ranges []eventRange, nThreads int) *progress {
+	p := &progress{
+		start:    time.Now(),
+		nThreads: nThreads,
+		nRanges:  len(ranges),
+		tty:      isTerminal(os.Stderr),
+		files:    files,
+		timings:  make(map[string]*fileTiming),
+		stop:     make(chan struct{}),
+	}
+	for _, inputPath := range files {
+		p.timings[inputPath] = &fileTiming{}
+	}
+	for _, r := range ranges {
+		p.timings[r.path].rangesLeft++
+	}
+
+	if p.tty {
+		p.stopped.Add(1)
+		go p.refresh()
+	}
+	return p
+}
+
+// isTerminal returns whether f is a terminal rather than a file or a pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// refresh redraws the status line until the run is over.
+func (p *progress) refresh() {
+	defer p.stopped.Done()
+
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			p.mu.Lock()
+			fmt.Fprint(os.Stderr, "\r\x1b[K")
+			p.mu.Unlock()
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			fmt.Fprintf(os.Stderr, "\r\x1b[K%v", p.status())
+			p.mu.Unlock()
+		}
+	}
+}
+
+// event counts an event read by a worker.
+func (p *progress) event() {
+	atomic.AddInt64(&p.nEvents, 1)
+}
+
+// rangeDone records that a worker spent elapsed reading nEvents events of a
+// range of the file at inputPath, and logs the file once all of its ranges
+// are done.
+func (p *progress) rangeDone(inputPath string, nEvents int, elapsed time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t := p.timings[inputPath]
+	t.rangesLeft--
+	t.nEvents += nEvents
+	t.elapsed += elapsed
+	p.rangesDone++
+	if t.rangesLeft > 0 {
+		return
+	}
+
+	p.nDone++
+	if p.tty {
+		fmt.Fprint(os.Stderr, "\r\x1b[K")
+	}
+	log.Printf("%v: %v events in %v; %v", inputPath, t.nEvents, formatDuration(t.elapsed), p.status())
+	if p.tty {
+		fmt.Fprint(os.Stderr, p.status())
+	}
+}
+
+// status summarizes the run so far.  The time left is estimated from the
+// fraction of the ranges done, which are of similar size within a file.
+func (p *progress) status() string {
+	elapsed := time.Since(p.start)
+	nEvents := atomic.LoadInt64(&p.nEvents)
+
+	eta := "?"
+	if p.rangesDone > 0 {
+		left := time.Duration(float64(elapsed) * float64(p.nRanges-p.rangesDone) / float64(p.rangesDone))
+		eta = formatDuration(left)
+	}
+	return fmt.Sprintf("%v/%v files done, %v events, %.1f events/s, ETA %v",
+		p.nDone, len(p.files), nEvents, rate(nEvents, elapsed), eta)
+}
+
+// finish stops the status line and writes the time spent on each file to w.
+// The time of a file split into ranges is summed over the workers that read
+// it, and so is what it takes a single worker.
+func (p *progress) finish(w io.Writer) {
+	close(p.stop)
+	p.stopped.Wait()
+
+	wall := time.Since(p.start)
+	nEvents := atomic.LoadInt64(&p.nEvents)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "file\tevents\ttime\tevents/s\t\n")
+	for _, inputPath := range p.files {
+		t := p.timings[inputPath]
+		if t.elapsed == 0 {
+			// not read before the run was interrupted
+			continue
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%.1f\t\n", inputPath, t.nEvents, formatDuration(t.elapsed), rate(int64(t.nEvents), t.elapsed))
+	}
+	fmt.Fprintf(tw, "total (-t %v)\t%v\t%v\t%.1f\t\n", p.nThreads, nEvents, formatDuration(wall), rate(nEvents, wall))
+	tw.Flush()
+}
+
+func rate(nEvents int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(nEvents) / elapsed.Seconds()
+}
+
+// formatDuration rounds d to a precision that suits its length.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	case d < time.Minute:
+		return d.Round(100 * time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
@@ -0,0 +1,263 @@
+package ana
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"go-hep.org/x/hep/lcio"
+	"go-hep.org/x/hep/sio"
+)
+
+// eventReader reads the events of an LCIO file like lcio.Reader, but can start
+// at any event found by indexEvents, so that the events of one file can be
+// shared out among workers.  Only run headers, event headers and events are
+// read; the LCIO random-access records are skipped.
+type eventReader struct {
+	stream     *sio.Stream
+	runHeader  lcio.RunHeader
+	runHeaders []lcio.RunHeader
+	header     lcio.EventHeader
+	event      lcio.Event
+	err        error
+}
+
+func openEventReader(path string) (*eventReader, error) {
+	stream, err := sio.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &eventReader{stream: stream}
+	rec := stream.Record(lcio.Records.RunHeader)
+	rec.SetUnpack(true)
+	if err := rec.Connect(lcio.Blocks.RunHeader, &r.runHeader); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	rec = stream.Record(lcio.Records.EventHeader)
+	rec.SetUnpack(true)
+	if err := rec.Connect(lcio.Blocks.EventHeader, &r.header); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	stream.Record(lcio.Records.Event).SetUnpack(true)
+	return r, nil
+}
+
+func (r *eventReader) Close() error {
+	return r.stream.Close()
+}
+
+// Seek positions the reader at the event indexed by e.
+func (r *eventReader) Seek(e indexedEvent) error {
+	r.header = e.header
+	if err := r.remap(); err != nil {
+		return err
+	}
+	_, err := r.stream.Seek(e.offset, io.SeekStart)
+	return err
+}
+
+// Next reads the next event, returning false at the end of the file or on an
+// error, which is then returned by Err.
+func (r *eventReader) Next() bool {
+	for r.err == nil {
+		rec, err := r.stream.ReadRecord()
+		if err != nil {
+			r.err = err
+			return false
+		}
+
+		switch rec.Name() {
+		case lcio.Records.RunHeader:
+			r.runHeaders = append(r.runHeaders, r.runHeader)
+		case lcio.Records.EventHeader:
+			r.err = r.remap()
+		case lcio.Records.Event:
+			return true
+		}
+	}
+	return false
+}
+
+func (r *eventReader) Event() lcio.Event {
+	return r.event
+}
+
+// RunHeaders returns the run headers read so far, in the order read.
+func (r *eventReader) RunHeaders() []lcio.RunHeader {
+	return r.runHeaders
+}
+
+// Err returns the error that ended reading, or io.EOF at the end of the
+// file.
+func (r *eventReader) Err() error {
+	return r.err
+}
+
+// remap connects new collections for the blocks listed in the current event
+// header to the event record, as lcio.Reader does.  Blocks of earlier events
+// stay connected, but are not decoded into unless the record holds them, and
+// then they are listed in the header too.
+func (r *eventReader) remap() error {
+	rec := r.stream.Record(lcio.Records.Event)
+	r.event = lcio.Event{
+		RunNumber:   r.header.RunNumber,
+		EventNumber: r.header.EventNumber,
+		TimeStamp:   r.header.TimeStamp,
+		Detector:    r.header.Detector,
+		Params:      r.header.Params,
+	}
+	for _, block := range r.header.Blocks {
+		coll := newCollection(block.Type)
+		if coll == nil {
+			continue
+		}
+		if err := rec.Connect(block.Name, coll); err != nil {
+			return err
+		}
+		r.event.Add(block.Name, coll)
+	}
+	return nil
+}
+
+// newCollection returns a new collection of the LCIO type typeName, or nil
+// if it is not one that go-hep can decode.
+func newCollection(typeName string) interface{} {
+	switch typeName {
+	case "MCParticle":
+		return new(lcio.McParticleContainer)
+	case "SimTrackerHit":
+		return new(lcio.SimTrackerHitContainer)
+	case "SimCalorimeterHit":
+		return new(lcio.SimCalorimeterHitContainer)
+	case "LCFloatVec":
+		return new(lcio.FloatVec)
+	case "LCIntVec":
+		return new(lcio.IntVec)
+	case "LCStrVec":
+		return new(lcio.StrVec)
+	case "RawCalorimeterHit":
+		return new(lcio.RawCalorimeterHitContainer)
+	case "CalorimeterHit":
+		return new(lcio.CalorimeterHitContainer)
+	case "TrackerData":
+		return new(lcio.TrackerDataContainer)
+	case "TrackerHit":
+		return new(lcio.TrackerHitContainer)
+	case "TrackerHitPlane":
+		return new(lcio.TrackerHitPlaneContainer)
+	case "TrackerHitZCylinder":
+		return new(lcio.TrackerHitZCylinderContainer)
+	case "TrackerPulse":
+		return new(lcio.TrackerPulseContainer)
+	case "TrackerRawData":
+		return new(lcio.TrackerRawDataContainer)
+	case "Track":
+		return new(lcio.TrackContainer)
+	case "Cluster":
+		return new(lcio.ClusterContainer)
+	case "Vertex":
+		return new(lcio.VertexContainer)
+	case "ReconstructedParticle":
+		return new(lcio.RecParticleContainer)
+	case "LCGenericObject":
+		return new(lcio.GenericObject)
+	case "LCRelation":
+		return new(lcio.RelationContainer)
+	}
+	if strings.HasSuffix(typeName, "_References") {
+		return new(lcio.References)
+	}
+	return nil
+}
+
+// CollectionType returns the LCIO type name of coll, a collection of one of
+// the types made by newCollection.
+func CollectionType(coll interface{}) string {
+	switch coll.(type) {
+	case *lcio.McParticleContainer:
+		return "MCParticle"
+	case *lcio.SimTrackerHitContainer:
+		return "SimTrackerHit"
+	case *lcio.SimCalorimeterHitContainer:
+		return "SimCalorimeterHit"
+	case *lcio.FloatVec:
+		return "LCFloatVec"
+	case *lcio.IntVec:
+		return "LCIntVec"
+	case *lcio.StrVec:
+		return "LCStrVec"
+	case *lcio.RawCalorimeterHitContainer:
+		return "RawCalorimeterHit"
+	case *lcio.CalorimeterHitContainer:
+		return "CalorimeterHit"
+	case *lcio.TrackerDataContainer:
+		return "TrackerData"
+	case *lcio.TrackerHitContainer:
+		return "TrackerHit"
+	case *lcio.TrackerHitPlaneContainer:
+		return "TrackerHitPlane"
+	case *lcio.TrackerHitZCylinderContainer:
+		return "TrackerHitZCylinder"
+	case *lcio.TrackerPulseContainer:
+		return "TrackerPulse"
+	case *lcio.TrackerRawDataContainer:
+		return "TrackerRawData"
+	case *lcio.TrackContainer:
+		return "Track"
+	case *lcio.ClusterContainer:
+		return "Cluster"
+	case *lcio.VertexContainer:
+		return "Vertex"
+	case *lcio.RecParticleContainer:
+		return "ReconstructedParticle"
+	case *lcio.GenericObject:
+		return "LCGenericObject"
+	case *lcio.RelationContainer:
+		return "LCRelation"
+	case *lcio.References:
+		return "References"
+	}
+	return fmt.Sprintf("%T", coll)
+}
+
+// indexedEvent locates an event in an LCIO file.  The header is kept, since
+// the event record that follows it cannot be decoded without the list of
+// collections it holds.
+type indexedEvent struct {
+	header lcio.EventHeader
+	// offset is the position of the event record in the file.
+	offset int64
+}
+
+// indexEvents reads the event headers of the file at path and returns where
+// each event is.  Not every writer adds the LCIO random-access records, so the
+// events are found by a pass over the headers instead, which is quick since
+// the event records are skipped without being decompressed.
+func indexEvents(path string) ([]indexedEvent, error) {
+	stream, err := sio.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	var header lcio.EventHeader
+	rec := stream.Record(lcio.Records.EventHeader)
+	rec.SetUnpack(true)
+	if err := rec.Connect(lcio.Blocks.EventHeader, &header); err != nil {
+		return nil, err
+	}
+
+	var index []indexedEvent
+	for {
+		if _, err := stream.ReadRecord(); err != nil {
+			if err == io.EOF {
+				return index, nil
+			}
+			return index, err
+		}
+		index = append(index, indexedEvent{header: header, offset: stream.CurPos()})
+	}
+}
@@ -0,0 +1,174 @@
+package ana
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"go-hep.org/x/hep/lcio"
+)
+
+// testEventID identifies an event of the test files by its run and event
+// numbers.
+type testEventID struct {
+	run, event int32
+}
+
+// writeTestFile writes nEvents events to an LCIO file at path, starting a new
+// run every runLength events, so that the run headers fall between events of
+// the ranges read by workers.  Each event holds an MCParticle whose PDG code
+// is the event number, and odd events also hold a second collection, so that
+// the collections change from one event to the next.
+func writeTestFile(t *testing.T, path string, nEvents, runLength int) {
+	t.Helper()
+
+	w, err := lcio.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < nEvents; i++ {
+		run := int32(i / runLength)
+		if i%runLength == 0 {
+			if err := w.WriteRunHeader(&lcio.RunHeader{RunNumber: run, Detector: "test"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		event := lcio.Event{RunNumber: run, EventNumber: int32(i), Detector: "test"}
+		event.Add("MCParticle", &lcio.McParticleContainer{
+			Particles: []lcio.McParticle{{PDG: int32(i), GenStatus: 1}},
+		})
+		if i%2 == 1 {
+			event.Add("Extra", &lcio.McParticleContainer{
+				Particles: []lcio.McParticle{{PDG: 11}, {PDG: -11}},
+			})
+		}
+		if err := w.WriteEvent(&event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readTestEvent returns the id of event, checking that its collections were
+// decoded into the ones listed in its header.
+func readTestEvent(event *lcio.Event) (testEventID, error) {
+	id := testEventID{event.RunNumber, event.EventNumber}
+	mc, ok := event.Get("MCParticle").(*lcio.McParticleContainer)
+	if !ok || len(mc.Particles) != 1 || mc.Particles[0].PDG != id.event {
+		return id, fmt.Errorf("event %v: bad MCParticle collection", id.event)
+	}
+
+	hasExtra := event.Has("Extra")
+	if hasExtra != (id.event%2 == 1) {
+		return id, fmt.Errorf("event %v: Extra collection present: %v", id.event, hasExtra)
+	}
+	if hasExtra {
+		extra, ok := event.Get("Extra").(*lcio.McParticleContainer)
+		if !ok || len(extra.Particles) != 2 {
+			return id, fmt.Errorf("event %v: bad Extra collection", id.event)
+		}
+	}
+	return id, nil
+}
+
+// Every event of the files must be read exactly once, in full, whatever the
+// number of ranges the files are split into.
+func TestSplitFiles(t *testing.T) {
+	tests := []struct {
+		nFiles    int
+		nEvents   int
+		runLength int
+		nThreads  int
+		nRanges   int
+	}{
+		{1, 10, 3, 1, 1},
+		{1, 10, 3, 2, 2},
+		{1, 10, 3, 3, 3},
+		{1, 10, 4, 7, 7},
+		{1, 10, 10, 10, 10},
+		{1, 3, 2, 8, 3},
+		{2, 9, 2, 5, 6},
+		{3, 5, 5, 3, 3},
+		{3, 5, 1, 2, 3},
+	}
+
+	for _, test := range tests {
+		name := fmt.Sprintf("%v files of %v events, %v threads", test.nFiles, test.nEvents, test.nThreads)
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			var files []string
+			for i := 0; i < test.nFiles; i++ {
+				path := filepath.Join(dir, fmt.Sprintf("%v.slcio", i))
+				writeTestFile(t, path, test.nEvents, test.runLength)
+				files = append(files, path)
+			}
+
+			ranges := splitFiles(files, test.nThreads)
+			if len(ranges) != test.nRanges {
+				t.Errorf("got %v ranges, want %v", len(ranges), test.nRanges)
+			}
+
+			nReads := make(map[string]map[testEventID]int)
+			for _, r := range ranges {
+				if nReads[r.path] == nil {
+					nReads[r.path] = make(map[testEventID]int)
+				}
+				analyze := func(event *lcio.Event, out func(result interface{})) error {
+					id, err := readTestEvent(event)
+					if err != nil {
+						t.Errorf("%v: %v", r.path, err)
+					}
+					nReads[r.path][id]++
+					return nil
+				}
+
+				s := newFileStats()
+				if err := readRange(context.Background(), r, analyze, func(interface{}) {}, s, &progress{}); err != nil {
+					t.Fatalf("%v: %v", r.path, err)
+				}
+				if r.nEvents > 0 && s.nEvents != r.nEvents {
+					t.Errorf("%v: read %v events of a range of %v", r.path, s.nEvents, r.nEvents)
+				}
+			}
+
+			for _, path := range files {
+				if len(nReads[path]) != test.nEvents {
+					t.Errorf("%v: read %v distinct events, want %v", path, len(nReads[path]), test.nEvents)
+				}
+				for id, n := range nReads[path] {
+					if n != 1 {
+						t.Errorf("%v: read event %v of run %v %v times", path, id.event, id.run, n)
+					}
+				}
+			}
+		})
+	}
+}
+
+// A file that cannot be indexed is left whole, so that it fails when read.
+func TestSplitFilesUnreadable(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.slcio")
+	writeTestFile(t, good, 4, 2)
+	missing := filepath.Join(dir, "missing.slcio")
+
+	ranges := splitFiles([]string{good, missing}, 4)
+	if len(ranges) != 3 {
+		t.Fatalf("got %v ranges, want 3", len(ranges))
+	}
+	last := ranges[len(ranges)-1]
+	if last.path != missing || last.start != nil || last.nEvents != 0 {
+		t.Errorf("got range %+v for the missing file, want it whole", last)
+	}
+
+	s := newFileStats()
+	if err := readRange(context.Background(), last, nil, nil, s, &progress{}); err == nil {
+		t.Error("reading the missing file: no error")
+	}
+}
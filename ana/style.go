@@ -0,0 +1,166 @@
+package ana
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go-hep.org/x/hep/hbook"
+	"go-hep.org/x/hep/hplot"
+
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+	"gonum.org/v1/plot/vg/vgpdf"
+)
+
+const (
+	PlotWidth  = 6 * vg.Inch
+	PlotHeight = 4 * vg.Inch
+)
+
+var (
+	Blue  = color.RGBA{B: 255, A: 255}
+	Red   = color.RGBA{R: 255, A: 255}
+	Green = color.RGBA{G: 255, A: 255}
+	White = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+)
+
+// NewPlot returns a plot with the title padding and legend placement shared
+// by all of the diagnostic figures.
+func NewPlot(title, xLabel, yLabel string) *hplot.Plot {
+	p := hplot.New()
+	p.Title.Text = title
+	p.Title.Padding = 2 * vg.Millimeter
+	p.X.Label.Text = xLabel
+	p.Y.Label.Text = yLabel
+	p.Legend.Left = true
+	p.Legend.Top = true
+	p.Legend.Padding = 2 * vg.Millimeter
+	return p
+}
+
+// SavePlot writes p to outputPath at the standard figure size.
+func SavePlot(p hplot.Drawer, outputPath string) error {
+	return hplot.Save(p, PlotWidth, PlotHeight, outputPath)
+}
+
+// LineStyle is the line color and dash pattern used to draw one set of
+// inputs.
+type LineStyle struct {
+	Color    color.Color
+	Dashes   []vg.Length
+	DashOffs vg.Length
+}
+
+// SetStyle returns the line style for the i-th of several overlaid input
+// sets, so that each set is distinguishable in color and dash pattern.
+func SetStyle(i int) LineStyle {
+	switch i {
+	case 0:
+		return LineStyle{Color: Blue}
+	case 1:
+		return LineStyle{Color: Red, Dashes: []vg.Length{1 * vg.Millimeter}}
+	case 2:
+		return LineStyle{
+			Color:    Green,
+			Dashes:   []vg.Length{1 * vg.Millimeter},
+			DashOffs: 1 * vg.Millimeter,
+		}
+	}
+	return LineStyle{Color: White}
+}
+
+// Apply sets the line style of h.
+func (s LineStyle) Apply(h *hplot.H1D) {
+	h.LineStyle.Color = s.Color
+	h.LineStyle.Dashes = s.Dashes
+	h.LineStyle.DashOffs = s.DashOffs
+}
+
+// NewErrorPlot returns a plotter drawing each point of data with its x and y
+// error bars in the color of s.
+func (s LineStyle) NewErrorPlot(data *hbook.S2D) *hplot.S2D {
+	h := hplot.NewS2D(data, hplot.WithXErrBars(true), hplot.WithYErrBars(true))
+	h.GlyphStyle.Color = s.Color
+	h.GlyphStyle.Shape = draw.CircleGlyph{}
+	h.GlyphStyle.Radius = 0.5 * vg.Millimeter
+	h.XErrs.LineStyle.Color = s.Color
+	h.YErrs.LineStyle.Color = s.Color
+	return h
+}
+
+// SavePages writes each of pages to outputPath at the given size, as
+// successive pages of one document if outputPath is a PDF, or otherwise as
+// separate files with the page number appended to the base name.
+func SavePages(pages []hplot.Drawer, width, height vg.Length, outputPath string) error {
+	ext := filepath.Ext(outputPath)
+	if ext != ".pdf" {
+		base := strings.TrimSuffix(outputPath, ext)
+		for i, page := range pages {
+			if err := hplot.Save(page, width, height, fmt.Sprintf("%s-%d%s", base, i, ext)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	c := vgpdf.New(width, height)
+	for i, page := range pages {
+		if i > 0 {
+			c.NextPage()
+		}
+		page.Draw(draw.New(c))
+	}
+
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	if _, err := c.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// NewEfficiencyMap returns a color map of the efficiencies in eff on a fixed
+// scale from 0 to 1, leaving blank the bins in which total, the denominator
+// of eff, has no weight.
+func NewEfficiencyMap(eff, total *hbook.H2D) *hplot.H2D {
+	h := hplot.NewH2D(eff, nil)
+	h.HeatMap = plotter.NewHeatMap(efficiencyGrid{eff: eff, total: total}, h.HeatMap.Palette)
+	h.HeatMap.Min = 0
+	h.HeatMap.Max = 1
+	h.HeatMap.NaN = color.Transparent
+	return h
+}
+
+// efficiencyGrid implements plotter.GridXYZ for an efficiency histogram,
+// reporting bins with an empty denominator as NaN.
+type efficiencyGrid struct {
+	eff, total *hbook.H2D
+}
+
+func (g efficiencyGrid) Dims() (c, r int) {
+	return g.eff.Binning.Nx, g.eff.Binning.Ny
+}
+
+func (g efficiencyGrid) Z(c, r int) float64 {
+	idx := r*g.eff.Binning.Nx + c
+	if g.total.Binning.Bins[idx].SumW() <= 0 {
+		return math.NaN()
+	}
+	return g.eff.Binning.Bins[idx].SumW()
+}
+
+func (g efficiencyGrid) X(c int) float64 {
+	return g.eff.Binning.Bins[c].XMid()
+}
+
+func (g efficiencyGrid) Y(r int) float64 {
+	return g.eff.Binning.Bins[r*g.eff.Binning.Nx].YMid()
+}
@@ -0,0 +1,200 @@
+package ana
+
+import (
+	"go-hep.org/x/hep/lcio"
+)
+
+// DefaultTrackerHitRelations are the LCRelation collections written by the
+// lcsim HelicalTrackHitDriver, relating the hits on tracks to the digitized
+// hits they were built from and to the MCParticles that produced them.
+var DefaultTrackerHitRelations = []string{"HelicalTrackMCRelations", "HelicalTrackHitRelations"}
+
+// DefaultCalorimeterHitRelations are the LCRelation collections relating
+// digitized calorimeter hits to the simulated hits they were made from.
+var DefaultCalorimeterHitRelations = []string{"CalorimeterHitRelations"}
+
+// HitTruth resolves tracker and calorimeter hits to the MCParticles that
+// produced them within one event.
+type HitTruth struct {
+	relations map[interface{}][]interface{}
+}
+
+// NewHitTruth indexes the named LCRelation collections of event.  Collections
+// that are absent from the event are ignored, and the number that were found
+// is returned so callers can detect files without truth relations.
+func NewHitTruth(event *lcio.Event, relationNames []string) (*HitTruth, int) {
+	ht := &HitTruth{relations: make(map[interface{}][]interface{})}
+
+	nFound := 0
+	for _, name := range relationNames {
+		relColl, ok := event.Get(name).(*lcio.RelationContainer)
+		if !ok {
+			continue
+		}
+		nFound++
+
+		for _, rel := range relColl.Rels {
+			if rel.From == nil || rel.To == nil {
+				continue
+			}
+			ht.relations[rel.From] = append(ht.relations[rel.From], rel.To)
+		}
+	}
+
+	return ht, nFound
+}
+
+// Particles returns the distinct MCParticles contributing to hit, following
+// relations from tracker hits to digitized and simulated hits.
+func (ht *HitTruth) Particles(hit *lcio.TrackerHit) []*lcio.McParticle {
+	var particles []*lcio.McParticle
+	visited := make(map[interface{}]bool)
+
+	var follow func(obj interface{})
+	follow = func(obj interface{}) {
+		if visited[obj] {
+			return
+		}
+		visited[obj] = true
+
+		switch obj := obj.(type) {
+		case *lcio.McParticle:
+			for _, particle := range particles {
+				if particle == obj {
+					return
+				}
+			}
+			particles = append(particles, obj)
+			return
+		case *lcio.SimTrackerHit:
+			if obj.Mc != nil {
+				follow(obj.Mc)
+			}
+		case *lcio.TrackerHit:
+			for _, rawHit := range obj.RawHits {
+				follow(rawHit)
+			}
+		}
+
+		for _, related := range ht.relations[obj] {
+			follow(related)
+		}
+	}
+	follow(hit)
+
+	return particles
+}
+
+// MatchTrack returns the MCParticle contributing the most hits to track and
+// the fraction of the track's hits it contributed.  If none of the hits can be
+// resolved to truth, MatchTrack returns nil.
+func (ht *HitTruth) MatchTrack(track *lcio.Track) (*lcio.McParticle, float64) {
+	if len(track.Hits) == 0 {
+		return nil, 0
+	}
+
+	nHits := make(map[*lcio.McParticle]int)
+	var best *lcio.McParticle
+	for _, hit := range track.Hits {
+		if hit == nil {
+			continue
+		}
+
+		for _, particle := range ht.Particles(hit) {
+			nHits[particle]++
+			if best == nil || nHits[particle] > nHits[best] {
+				best = particle
+			}
+		}
+	}
+
+	if best == nil {
+		return nil, 0
+	}
+	return best, float64(nHits[best]) / float64(len(track.Hits))
+}
+
+// CalorimeterEnergies returns the simulated energy deposited in hit by each
+// MCParticle, following relations and raw hits to simulated calorimeter hits.
+// The contributions are attributed to the final-state ancestors of the
+// particles recorded in the simulated hits, so that the secondaries of a
+// shower count toward the particle that started it.
+func (ht *HitTruth) CalorimeterEnergies(hit *lcio.CalorimeterHit) map[*lcio.McParticle]float64 {
+	energies := make(map[*lcio.McParticle]float64)
+	visited := make(map[interface{}]bool)
+
+	var follow func(obj interface{})
+	follow = func(obj interface{}) {
+		if visited[obj] {
+			return
+		}
+		visited[obj] = true
+
+		switch obj := obj.(type) {
+		case *lcio.SimCalorimeterHit:
+			for _, contrib := range obj.Contributions {
+				if contrib.Mc != nil {
+					energies[FinalStateAncestor(contrib.Mc)] += float64(contrib.Energy)
+				}
+			}
+		case *lcio.CalorimeterHit:
+			if obj.Raw != nil {
+				follow(obj.Raw)
+			}
+		}
+
+		for _, related := range ht.relations[obj] {
+			follow(related)
+		}
+	}
+	follow(hit)
+
+	return energies
+}
+
+// MatchCluster returns the MCParticle that deposited the most simulated
+// energy in the hits of cluster, and the fraction of the simulated energy it
+// deposited.  If none of the hits can be resolved to truth, MatchCluster
+// returns nil.
+func (ht *HitTruth) MatchCluster(cluster *lcio.Cluster) (*lcio.McParticle, float64) {
+	energies := make(map[*lcio.McParticle]float64)
+	total := 0.
+	for _, hit := range cluster.Hits {
+		if hit == nil {
+			continue
+		}
+
+		for particle, energy := range ht.CalorimeterEnergies(hit) {
+			energies[particle] += energy
+			total += energy
+		}
+	}
+
+	var best *lcio.McParticle
+	for particle, energy := range energies {
+		if best == nil || energy > energies[best] {
+			best = particle
+		}
+	}
+
+	if best == nil || total <= 0 {
+		return nil, 0
+	}
+	return best, energies[best] / total
+}
+
+// FinalStateAncestor returns the nearest ancestor of particle, or particle
+// itself, that is in the generator final state.  Particles without such an
+// ancestor are returned as is.
+func FinalStateAncestor(particle *lcio.McParticle) *lcio.McParticle {
+	for ancestor := particle; ancestor != nil; {
+		if ancestor.GenStatus == 1 {
+			return ancestor
+		}
+		if len(ancestor.Parents) == 0 {
+			break
+		}
+		ancestor = ancestor.Parents[0]
+	}
+	return particle
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"go-hep.org/x/hep/hbook"
+	"go-hep.org/x/hep/hplot"
+	"go-hep.org/x/hep/lcio"
+
+	"gonum.org/v1/plot"
+
+	"github.com/decibelcooper/SiEIC/ana"
+)
+
+// ConfusionResult pairs the type of an MCParticle with that of the PFO
+// matched to it, either of which may be nParticleTypes if unmatched.  Eta is
+// that of the MCParticle, or of the PFO if there is no MCParticle.
+type ConfusionResult struct {
+	Eta  float64
+	True ParticleType
+	Reco ParticleType
+}
+
+// etaRegion is a range of eta in which the confusion matrix is shown.
+type etaRegion struct {
+	min, max float64
+}
+
+func (r etaRegion) contains(eta float64) bool {
+	return eta >= r.min && eta < r.max
+}
+
+func (r etaRegion) String() string {
+	return fmt.Sprintf("%g <= eta < %g", r.min, r.max)
+}
+
+// parseEtaRegions returns the inclusive region followed by those between
+// successive comma-separated edges in list.
+func parseEtaRegions(list string) ([]etaRegion, error) {
+	regions := []etaRegion{{math.Inf(-1), math.Inf(1)}}
+	if list == "" {
+		return regions, nil
+	}
+
+	var edges []float64
+	for _, field := range strings.Split(list, ",") {
+		edge, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid eta region edge %q", field)
+		}
+		if len(edges) > 0 && edge <= edges[len(edges)-1] {
+			return nil, fmt.Errorf("eta region edges must increase: %v", list)
+		}
+		edges = append(edges, edge)
+	}
+	if len(edges) < 2 {
+		return nil, fmt.Errorf("need at least two eta region edges: %v", list)
+	}
+
+	for i := 1; i < len(edges); i++ {
+		regions = append(regions, etaRegion{edges[i-1], edges[i]})
+	}
+	return regions, nil
+}
+
+// confusionCounts accumulate the confusion matrix of each of regions for one
+// worker.
+type confusionCounts struct {
+	regions []etaRegion
+	counts  [][nParticleTypes + 1][nParticleTypes + 1]int
+}
+
+func (c *confusionCounts) Collect(result interface{}) {
+	switch result := result.(type) {
+	case ConfusionResult:
+		for i, region := range c.regions {
+			if region.contains(result.Eta) {
+				c.counts[i][result.True][result.Reco]++
+			}
+		}
+	}
+}
+
+func (c *confusionCounts) Merge(other ana.Collector) {
+	o := other.(*confusionCounts)
+	for i := range c.counts {
+		for trueType := range c.counts[i] {
+			for recoType := range c.counts[i][trueType] {
+				c.counts[i][trueType][recoType] += o.counts[i][trueType][recoType]
+			}
+		}
+	}
+}
+
+// drawConfusion analyzes inputFiles, prints the confusion matrix of each of
+// regions and returns a page with each matrix drawn normalized to the number
+// of MCParticles of each type.
+func drawConfusion(inputFiles []string, regions []etaRegion, label string) []hplot.Drawer {
+	fileSet := ana.FileSet{Files: inputFiles, NThreads: *nThreads, MaxFiles: *maxFiles, MaxFailFrac: *maxFailFrac}
+	counts := fileSet.Run(ctx, analyzeConfusion, func() ana.Collector {
+		return &confusionCounts{
+			regions: regions,
+			counts:  make([][nParticleTypes + 1][nParticleTypes + 1]int, len(regions)),
+		}
+	}).(*confusionCounts).counts
+
+	var pages []hplot.Drawer
+	for i, region := range regions {
+		title := "PFO Type Confusion"
+		if *inputsAreDirs {
+			title += ": " + label
+		}
+		if i > 0 {
+			title += ", " + region.String()
+		}
+
+		fmt.Printf("%v\n", title)
+		printConfusion(os.Stdout, &counts[i])
+		fmt.Println()
+
+		countHist := confusionHist(&counts[i])
+		countHist.Annotation()["title"] = title
+		savedHists.Add(fmt.Sprintf("%v/confusion/%d", label, i), countHist, "PFO type", "MCParticle type")
+
+		pages = append(pages, drawConfusionMatrix(&counts[i], title))
+	}
+	return pages
+}
+
+// printConfusion writes a table of counts with a row per MCParticle type and
+// a column per PFO type.
+func printConfusion(w io.Writer, counts *[nParticleTypes + 1][nParticleTypes + 1]int) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(tw, "true \\ reco\t")
+	for _, name := range particleTypeNames {
+		fmt.Fprintf(tw, "%v\t", name)
+	}
+	fmt.Fprintln(tw)
+
+	for trueType, row := range counts {
+		fmt.Fprintf(tw, "%v\t", particleTypeNames[trueType])
+		for _, count := range row {
+			fmt.Fprintf(tw, "%v\t", count)
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+}
+
+// confusionHist returns counts as a histogram with the PFO type along x and
+// the MCParticle type along y, in bins of unit width from 0.
+func confusionHist(counts *[nParticleTypes + 1][nParticleTypes + 1]int) *hbook.H2D {
+	edges := ana.LinearEdges(0, float64(nParticleTypes+1), int(nParticleTypes+1))
+	h := hbook.NewH2DFromEdges(edges, edges)
+	for trueType, row := range counts {
+		for recoType, count := range row {
+			if count > 0 {
+				h.Fill(float64(recoType)+0.5, float64(trueType)+0.5, float64(count))
+			}
+		}
+	}
+	return h
+}
+
+// drawConfusionMatrix returns a figure of counts as fractions of each row,
+// with its color scale.
+func drawConfusionMatrix(counts *[nParticleTypes + 1][nParticleTypes + 1]int, title string) hplot.Drawer {
+	edges := ana.LinearEdges(0, float64(nParticleTypes+1), int(nParticleTypes+1))
+	fractionHist := hbook.NewH2DFromEdges(edges, edges)
+	// rowHist has an entry in every cell of a non-empty row, so that empty
+	// rows are left blank rather than drawn as zero
+	rowHist := hbook.NewH2DFromEdges(edges, edges)
+	for trueType, row := range counts {
+		rowTotal := 0
+		for _, count := range row {
+			rowTotal += count
+		}
+		if rowTotal == 0 {
+			continue
+		}
+
+		for recoType, count := range row {
+			x, y := float64(recoType)+0.5, float64(trueType)+0.5
+			fractionHist.Fill(x, y, float64(count)/float64(rowTotal))
+			rowHist.Fill(x, y, 1)
+		}
+	}
+
+	p := ana.NewPlot(title, "PFO type", "MCParticle type")
+	var ticks []plot.Tick
+	for i, name := range particleTypeNames {
+		ticks = append(ticks, plot.Tick{Value: float64(i) + 0.5, Label: name})
+	}
+	p.X.Tick.Marker = plot.ConstantTicks(ticks)
+	p.Y.Tick.Marker = plot.ConstantTicks(ticks)
+
+	matrix := ana.NewEfficiencyMap(fractionHist, rowHist)
+	p.Add(matrix)
+
+	legend := matrix.Legend()
+	legend.Left = false
+	return hplot.Figure(p, hplot.WithLegend(legend))
+}
+
+// analyzeConfusion sends the types of each MCParticle and the PFO matched to
+// it, and of the unmatched PFOs.
+func analyzeConfusion(event *lcio.Event, out func(result interface{})) error {
+	truthColl, err := ana.MCParticles(event, *mcName)
+	if err != nil {
+		return err
+	}
+	pfoColl, err := ana.PFOs(event, *pfoName)
+	if err != nil {
+		return err
+	}
+	truths, matches, pfoPs := matchEvent(truthColl, pfoColl, maxMatchEnergyFrac)
+
+	used := make([]bool, len(pfoColl.Parts))
+	for i, truth := range truths {
+		p := frame.Momentum(ana.Vec3(truth.P), truth.Energy())
+		result := ConfusionResult{Eta: p.Eta(), True: particleTypeFromPDG(truth.PDG), Reco: nParticleTypes}
+		if match := matches[i]; match >= 0 {
+			used[match] = true
+			result.Reco = particleTypeFromPDG(pfoColl.Parts[match].Type)
+		}
+		out(result)
+	}
+
+	for i, pfo := range pfoColl.Parts {
+		if !used[i] {
+			out(ConfusionResult{Eta: pfoPs[i].Eta(), True: nParticleTypes, Reco: particleTypeFromPDG(pfo.Type)})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"math"
+
+	"go-hep.org/x/hep/hbook"
+	"go-hep.org/x/hep/hplot"
+	"go-hep.org/x/hep/lcio"
+
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+
+	"github.com/decibelcooper/SiEIC/ana"
+)
+
+const (
+	bField          = 2.5 // Tesla, from compact_dd4hep.xml
+	electronPDG     = 11
+	nElecEtaBins    = 20
+	nElecEnergyBins = 20
+	minPionEnergy   = 1
+	maxEOverP       = 2
+	nEOverPBins     = 50
+	elecPageWidth   = 10 * vg.Inch
+	elecPageHeight  = 10 * vg.Inch
+)
+
+// ElectronResult describes the scattered electron, taken to be the most
+// energetic final-state truth electron, and whether the PFO matched to it is
+// identified as an electron.
+type ElectronResult struct {
+	Eta        float64
+	Energy     float64
+	Identified bool
+}
+
+// CandidateResult describes the electron candidate, the most energetic PFO
+// identified as an electron, and whether it is matched to the scattered
+// electron.
+type CandidateResult struct {
+	Eta    float64
+	Energy float64
+	Pure   bool
+}
+
+// PionResult describes a final-state truth charged pion and whether the PFO
+// matched to it is identified as an electron.
+type PionResult struct {
+	Eta    float64
+	Energy float64
+	MisID  bool
+}
+
+// EOverPResult holds the ratio of cluster energy to track momentum of the
+// PFO matched to a truth electron or charged pion, with the eta and energy of
+// the truth particle.
+type EOverPResult struct {
+	Electron bool
+	Eta      float64
+	Energy   float64
+	EOverP   float64
+}
+
+// newElectronIDPages returns a page of electron identification and pion
+// misidentification rates versus eta and energy, and a page of E/p.  The E/p
+// maps versus eta and energy of each input set are drawn by drawElectronID
+// on pages of their own.
+func newElectronIDPages() []*hplot.TiledPlot {
+	ratePage := hplot.NewTiledPlot(draw.Tiles{
+		Rows: 3,
+		Cols: 2,
+		PadX: 5 * vg.Millimeter,
+		PadY: 5 * vg.Millimeter,
+	})
+	labels := [][3]string{
+		{"Electron ID Efficiency vs. eta", "true eta", "efficiency"},
+		{"Electron ID Efficiency vs. Energy", "true energy {GeV}", "efficiency"},
+		{"Electron Candidate Purity vs. eta", "candidate eta", "purity"},
+		{"Electron Candidate Purity vs. Energy", "candidate energy {GeV}", "purity"},
+		{"Pion Misidentification vs. eta", "true eta", "misidentification rate"},
+		{"Pion Misidentification vs. Energy", "true energy {GeV}", "misidentification rate"},
+	}
+	for i, label := range labels {
+		*ratePage.Plot(i%2, i/2) = *ana.NewPlot(label[0], label[1], label[2])
+	}
+
+	eOverPPage := hplot.NewTiledPlot(draw.Tiles{
+		Rows: 2,
+		Cols: 1,
+		PadY: 5 * vg.Millimeter,
+	})
+	*eOverPPage.Plot(0, 0) = *ana.NewPlot("Electron E/p", "cluster energy / track momentum", "count")
+	*eOverPPage.Plot(0, 1) = *ana.NewPlot("Charged Pion E/p", "cluster energy / track momentum", "count")
+
+	return []*hplot.TiledPlot{ratePage, eOverPPage}
+}
+
+// electronIDHists accumulate the electron identification results of one
+// worker.
+type electronIDHists struct {
+	elecEta, elecEnergy   *hbook.H1D
+	idEta, idEnergy       *hbook.H1D
+	candEta, candEnergy   *hbook.H1D
+	pureEta, pureEnergy   *hbook.H1D
+	pionEta, pionEnergy   *hbook.H1D
+	misIDEta, misIDEnergy *hbook.H1D
+	elecEOverP            *hbook.H1D
+	pionEOverP            *hbook.H1D
+
+	elecEOverPVsEta, elecEOverPVsEnergy *hbook.H2D
+	pionEOverPVsEta, pionEOverPVsEnergy *hbook.H2D
+}
+
+func newElectronIDHists() ana.Collector {
+	newEtaHist := func() *hbook.H1D { return hbook.NewH1D(nElecEtaBins, minEta, maxEta) }
+	newEnergyHist := func() *hbook.H1D { return hbook.NewH1D(nElecEnergyBins, 0, *maxEnergy) }
+
+	return &electronIDHists{
+		elecEta: newEtaHist(), elecEnergy: newEnergyHist(),
+		idEta: newEtaHist(), idEnergy: newEnergyHist(),
+		candEta: newEtaHist(), candEnergy: newEnergyHist(),
+		pureEta: newEtaHist(), pureEnergy: newEnergyHist(),
+		pionEta: newEtaHist(), pionEnergy: newEnergyHist(),
+		misIDEta: newEtaHist(), misIDEnergy: newEnergyHist(),
+		elecEOverP: hbook.NewH1D(nEOverPBins, 0, maxEOverP),
+		pionEOverP: hbook.NewH1D(nEOverPBins, 0, maxEOverP),
+
+		elecEOverPVsEta:    hbook.NewH2D(nElecEtaBins, minEta, maxEta, nEOverPBins, 0, maxEOverP),
+		elecEOverPVsEnergy: hbook.NewH2D(nElecEnergyBins, 0, *maxEnergy, nEOverPBins, 0, maxEOverP),
+		pionEOverPVsEta:    hbook.NewH2D(nElecEtaBins, minEta, maxEta, nEOverPBins, 0, maxEOverP),
+		pionEOverPVsEnergy: hbook.NewH2D(nElecEnergyBins, 0, *maxEnergy, nEOverPBins, 0, maxEOverP),
+	}
+}
+
+func (h *electronIDHists) Collect(result interface{}) {
+	switch result := result.(type) {
+	case ElectronResult:
+		h.elecEta.Fill(result.Eta, 1)
+		h.elecEnergy.Fill(result.Energy, 1)
+		if result.Identified {
+			h.idEta.Fill(result.Eta, 1)
+			h.idEnergy.Fill(result.Energy, 1)
+		}
+	case CandidateResult:
+		h.candEta.Fill(result.Eta, 1)
+		h.candEnergy.Fill(result.Energy, 1)
+		if result.Pure {
+			h.pureEta.Fill(result.Eta, 1)
+			h.pureEnergy.Fill(result.Energy, 1)
+		}
+	case PionResult:
+		h.pionEta.Fill(result.Eta, 1)
+		h.pionEnergy.Fill(result.Energy, 1)
+		if result.MisID {
+			h.misIDEta.Fill(result.Eta, 1)
+			h.misIDEnergy.Fill(result.Energy, 1)
+		}
+	case EOverPResult:
+		if result.Electron {
+			h.elecEOverP.Fill(result.EOverP, 1)
+			h.elecEOverPVsEta.Fill(result.Eta, result.EOverP, 1)
+			h.elecEOverPVsEnergy.Fill(result.Energy, result.EOverP, 1)
+		} else {
+			h.pionEOverP.Fill(result.EOverP, 1)
+			h.pionEOverPVsEta.Fill(result.Eta, result.EOverP, 1)
+			h.pionEOverPVsEnergy.Fill(result.Energy, result.EOverP, 1)
+		}
+	}
+}
+
+func (h *electronIDHists) all() []*hbook.H1D {
+	return []*hbook.H1D{
+		h.elecEta, h.elecEnergy, h.idEta, h.idEnergy, h.candEta, h.candEnergy,
+		h.pureEta, h.pureEnergy, h.pionEta, h.pionEnergy, h.misIDEta, h.misIDEnergy,
+		h.elecEOverP, h.pionEOverP,
+	}
+}
+
+func (h *electronIDHists) maps() []*hbook.H2D {
+	return []*hbook.H2D{h.elecEOverPVsEta, h.elecEOverPVsEnergy, h.pionEOverPVsEta, h.pionEOverPVsEnergy}
+}
+
+func (h *electronIDHists) Merge(other ana.Collector) {
+	o := other.(*electronIDHists)
+	ana.MergeH1Ds(h.all(), o.all())
+	otherMaps := o.maps()
+	for i, m := range h.maps() {
+		ana.MergeH2D(m, otherMaps[i])
+	}
+}
+
+// save adds the histograms to savedHists under set, along with the rates
+// drawn from them.
+func (h *electronIDHists) save(set string, interval ana.Interval) {
+	vars := []struct {
+		name, xLabel                      string
+		elec, id, cand, pure, pion, misID *hbook.H1D
+	}{
+		{"Eta", "eta", h.elecEta, h.idEta, h.candEta, h.pureEta, h.pionEta, h.misIDEta},
+		{"Energy", "energy {GeV}", h.elecEnergy, h.idEnergy, h.candEnergy, h.pureEnergy, h.pionEnergy, h.misIDEnergy},
+	}
+	for _, v := range vars {
+		savedHists.Add(set+"/elec"+v.name, v.elec, "true "+v.xLabel, "count")
+		savedHists.Add(set+"/id"+v.name, v.id, "true "+v.xLabel, "count")
+		savedHists.Add(set+"/cand"+v.name, v.cand, "candidate "+v.xLabel, "count")
+		savedHists.Add(set+"/pure"+v.name, v.pure, "candidate "+v.xLabel, "count")
+		savedHists.Add(set+"/pion"+v.name, v.pion, "true "+v.xLabel, "count")
+		savedHists.Add(set+"/misID"+v.name, v.misID, "true "+v.xLabel, "count")
+
+		savedHists.AddEfficiency(set+"/idEff"+v.name, set+"/id"+v.name, set+"/elec"+v.name, interval, "efficiency")
+		savedHists.AddEfficiency(set+"/purity"+v.name, set+"/pure"+v.name, set+"/cand"+v.name, interval, "purity")
+		savedHists.AddEfficiency(set+"/misIDRate"+v.name, set+"/misID"+v.name, set+"/pion"+v.name, interval, "misidentification rate")
+	}
+
+	savedHists.Add(set+"/elecEOverP", h.elecEOverP, "cluster energy / track momentum", "count")
+	savedHists.Add(set+"/pionEOverP", h.pionEOverP, "cluster energy / track momentum", "count")
+	savedHists.Add(set+"/elecEOverPVsEta", h.elecEOverPVsEta, "true eta", "cluster energy / track momentum")
+	savedHists.Add(set+"/elecEOverPVsEnergy", h.elecEOverPVsEnergy, "true energy {GeV}", "cluster energy / track momentum")
+	savedHists.Add(set+"/pionEOverPVsEta", h.pionEOverPVsEta, "true eta", "cluster energy / track momentum")
+	savedHists.Add(set+"/pionEOverPVsEnergy", h.pionEOverPVsEnergy, "true energy {GeV}", "cluster energy / track momentum")
+}
+
+// drawElectronID analyzes inputFiles and adds the electron identification
+// distributions to the pages made by newElectronIDPages.  It returns a page of
+// the E/p maps of the set versus eta and energy, which cannot be overlaid
+// with those of other sets.
+func drawElectronID(inputFiles []string, pages []*hplot.TiledPlot, style ana.LineStyle, label string, interval ana.Interval) hplot.Drawer {
+	fileSet := ana.FileSet{Files: inputFiles, NThreads: *nThreads, MaxFiles: *maxFiles, MaxFailFrac: *maxFailFrac}
+	h := fileSet.Run(ctx, analyzeElectronID, newElectronIDHists).(*electronIDHists)
+	h.save(label, interval)
+
+	rates := [][2]*hbook.H1D{
+		{h.idEta, h.elecEta},
+		{h.idEnergy, h.elecEnergy},
+		{h.pureEta, h.candEta},
+		{h.pureEnergy, h.candEnergy},
+		{h.misIDEta, h.pionEta},
+		{h.misIDEnergy, h.pionEnergy},
+	}
+	for i, rate := range rates {
+		tile := pages[0].Plot(i%2, i/2)
+		hRate := style.NewErrorPlot(ana.Efficiency(rate[0], rate[1], interval))
+		tile.Add(hRate)
+		if *inputsAreDirs {
+			tile.Legend.Add(label, hRate)
+		}
+	}
+
+	for i, hist := range []*hbook.H1D{h.elecEOverP, h.pionEOverP} {
+		tile := pages[1].Plot(0, i)
+		hEOverP := hplot.NewH1D(hist)
+		style.Apply(hEOverP)
+		if !*inputsAreDirs {
+			hEOverP.Infos.Style = hplot.HInfoSummary
+		}
+		tile.Add(hEOverP)
+		tile.Legend.Add(label, hEOverP)
+	}
+
+	mapPage := hplot.NewTiledPlot(draw.Tiles{
+		Rows: 2,
+		Cols: 2,
+		PadX: 5 * vg.Millimeter,
+		PadY: 5 * vg.Millimeter,
+	})
+	maps := []struct {
+		title, xLabel string
+		hist          *hbook.H2D
+	}{
+		{"Electron E/p vs. eta", "true eta", h.elecEOverPVsEta},
+		{"Charged Pion E/p vs. eta", "true eta", h.pionEOverPVsEta},
+		{"Electron E/p vs. Energy", "true energy {GeV}", h.elecEOverPVsEnergy},
+		{"Charged Pion E/p vs. Energy", "true energy {GeV}", h.pionEOverPVsEnergy},
+	}
+	for i, m := range maps {
+		tile := mapPage.Plot(i%2, i/2)
+		*tile = *ana.NewPlot(m.title+" ("+label+")", m.xLabel, "cluster energy / track momentum")
+		tile.Add(hplot.NewH2D(m.hist, nil))
+	}
+	return mapPage
+}
+
+func analyzeElectronID(event *lcio.Event, out func(result interface{})) error {
+	truthColl, err := ana.MCParticles(event, *mcName)
+	if err != nil {
+		return err
+	}
+	pfoColl, err := ana.PFOs(event, *pfoName)
+	if err != nil {
+		return err
+	}
+
+	pfoPs := make([]ana.Vec3, len(pfoColl.Parts))
+	pfoEnergies := make([]float64, len(pfoColl.Parts))
+	for i, pfo := range pfoColl.Parts {
+		pfoPs[i], pfoEnergies[i] = frame.FourMomentum(ana.Vec3From32(pfo.P), float64(pfo.Energy))
+	}
+
+	candidate := -1
+	for i, pfo := range pfoColl.Parts {
+		if particleTypeFromPDG(pfo.Type) != ELEC {
+			continue
+		}
+		if candidate < 0 || pfo.Energy > pfoColl.Parts[candidate].Energy {
+			candidate = i
+		}
+	}
+
+	// the scattered electron is taken to be the most energetic one, leaving
+	// out positrons from conversions and heavy-flavour decays
+	var electron *lcio.McParticle
+	for i, truth := range truthColl.Particles {
+		if truth.GenStatus != 1 || truth.PDG != electronPDG {
+			continue
+		}
+		if electron == nil || truth.Energy() > electron.Energy() {
+			electron = &truthColl.Particles[i]
+		}
+	}
+
+	electronMatch := -1
+	if electron != nil {
+		p, energy := frame.FourMomentum(ana.Vec3(electron.P), electron.Energy())
+		electronMatch = matchPFO(p, energy, maxMatchEnergyFrac, pfoPs, pfoEnergies, nil)
+
+		identified := electronMatch >= 0 && particleTypeFromPDG(pfoColl.Parts[electronMatch].Type) == ELEC
+		out(ElectronResult{Eta: p.Eta(), Energy: energy, Identified: identified})
+
+		if electronMatch >= 0 {
+			if eOverP, ok := pfoEOverP(&pfoColl.Parts[electronMatch]); ok {
+				out(EOverPResult{Electron: true, Eta: p.Eta(), Energy: energy, EOverP: eOverP})
+			}
+		}
+	}
+
+	if candidate >= 0 {
+		out(CandidateResult{
+			Eta:    pfoPs[candidate].Eta(),
+			Energy: pfoEnergies[candidate],
+			Pure:   candidate == electronMatch,
+		})
+	}
+
+	for _, truth := range truthColl.Particles {
+		if truth.GenStatus != 1 || truth.Charge == 0 || particleTypeFromPDG(truth.PDG) != PION {
+			continue
+		}
+		if truth.Energy() < minPionEnergy {
+			continue
+		}
+
+		p, energy := frame.FourMomentum(ana.Vec3(truth.P), truth.Energy())
+		match := matchPFO(p, energy, maxMatchEnergyFrac, pfoPs, pfoEnergies, nil)
+
+		misID := match >= 0 && particleTypeFromPDG(pfoColl.Parts[match].Type) == ELEC
+		out(PionResult{Eta: p.Eta(), Energy: energy, MisID: misID})
+
+		if match >= 0 {
+			if eOverP, ok := pfoEOverP(&pfoColl.Parts[match]); ok {
+				out(EOverPResult{Electron: false, Eta: p.Eta(), Energy: energy, EOverP: eOverP})
+			}
+		}
+	}
+	return nil
+}
+
+// pfoEOverP returns the ratio of the summed energy of the clusters of pfo to
+// the momentum of its first track, and false if it lacks either.
+func pfoEOverP(pfo *lcio.RecParticle) (float64, bool) {
+	if len(pfo.Tracks) == 0 || pfo.Tracks[0] == nil || len(pfo.Clusters) == 0 {
+		return 0, false
+	}
+
+	clusterEnergy := 0.
+	for _, cluster := range pfo.Clusters {
+		if cluster != nil {
+			clusterEnergy += float64(cluster.Energy)
+		}
+	}
+
+	track := pfo.Tracks[0]
+	trackP := ana.TrackPT(track.Omega(), bField) * math.Sqrt(1+track.TanL()*track.TanL())
+	if trackP <= 0 || math.IsInf(trackP, 0) {
+		return 0, false
+	}
+	return clusterEnergy / trackP, true
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"image/color"
+	"log"
+	"math"
+	"os"
+	"path"
+
+	"go-hep.org/x/hep/hbook"
+	"go-hep.org/x/hep/hplot"
+	"go-hep.org/x/hep/lcio"
+
+	"github.com/decibelcooper/SiEIC/ana"
+)
+
+var (
+	crossingAngleSource = flag.String("xangle", ana.DefaultCrossingAngleSource, "compact detector description or SLIC macro defining the crossing angle")
+	doConfusion         = flag.Bool("confusion", false, "plot and print the matrix of PFO type versus matched MCParticle type")
+	doResolution        = flag.Bool("r", false, "plot PFO energy resolution for neutrals and momentum resolution for charged particles, one page per class")
+	doElectronID        = flag.Bool("elec", false, "plot scattered-electron identification efficiency, purity, pion misidentification and E/p")
+	energyWeighted      = flag.Bool("e", false, "weight distribution by energy")
+	etaRegions          = flag.String("etaregions", "", "comma-separated eta edges of regions in which to also show the confusion matrix")
+	frameName           = flag.String("frame", "lab", "frame in which to histogram kinematics: lab, or headon to remove the crossing-angle boost")
+	inputsAreDirs       = flag.Bool("d", false, "inputs are directories")
+	intervalName        = flag.String("ci", "clopper-pearson", "binomial interval for efficiencies and rates: clopper-pearson or wilson")
+	maxEnergy           = flag.Float64("emax", 50, "maximum energy in GeV of the electron identification plots of -elec")
+	maxFailFrac         = flag.Float64("maxfail", 0, "fraction of input files that may fail to be read before exiting with status 1")
+	maxFiles            = flag.Int("m", math.MaxInt32, "maximum number of files to process")
+	mcName              = flag.String("mc", ana.DefaultMCParticles, "name of the MCParticle collection")
+	normalize           = flag.Bool("n", false, "normalize PFO count to MCParticle count")
+	nThreads            = flag.Int("t", 2, "number of concurrent files to process")
+	outputPath          = flag.String("o", "out.pdf", "path of output file")
+	perType             = flag.Bool("types", false, "draw distributions per particle type instead of charged and neutral")
+	pfoName             = flag.String("pfos", ana.DefaultPFOs, "name of the ReconstructedParticle collection")
+	p_TWeighted         = flag.Bool("ptw", false, "weight distribution by p_T")
+	resMaxEnergy        = flag.Float64("resemax", 50, "maximum true energy in GeV of the resolution curves of -r")
+)
+
+var (
+	ctx        context.Context
+	frame      ana.Frame
+	savedHists ana.Hists
+)
+
+const (
+	minEta            = -5
+	maxEta            = 5
+	nEtaBins          = 50
+	truthChargedMinPT = 0.5
+)
+
+type ParticleType uint8
+
+const (
+	ELEC ParticleType = iota
+	PION
+	PROTON
+	PHOTON
+	NEUTRON
+	OTHER
+)
+
+// nParticleTypes is also used as the type of the missing partner of an
+// unmatched particle in the confusion matrix.
+const nParticleTypes = OTHER + 1
+
+var particleTypeNames = [nParticleTypes + 1]string{"e", "pi", "p", "gamma", "n", "other", "none"}
+
+// particleTypeColors are used for the PFO distributions of each type in
+// per-type mode, and are lightened for the MCParticle distributions.
+var particleTypeColors = [nParticleTypes]color.RGBA{
+	ELEC:    {R: 255, B: 255, A: 255},
+	PION:    {B: 255, A: 255},
+	PROTON:  {G: 180, B: 180, A: 255},
+	PHOTON:  {G: 255, A: 255},
+	NEUTRON: {R: 255, G: 140, A: 255},
+	OTHER:   {A: 255},
+}
+
+type Result struct {
+	Charge float32
+	Eta    float64
+	Type   ParticleType
+	Weight float64
+}
+
+type TrueResult Result
+
+type PFOResult Result
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: PFODist [options] <lcio-input-file>
+options:
+`,
+		)
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+	defer ana.ExitIfFailed()
+	ctx = ana.InterruptContext()
+
+	var err error
+	frame, err = ana.NewFrame(*frameName, *crossingAngleSource)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *doConfusion {
+		regions, err := parseEtaRegions(*etaRegions)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var pages []hplot.Drawer
+		if *inputsAreDirs {
+			for _, dir := range flag.Args() {
+				inputFiles, err := ana.DirFiles(dir)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				pages = append(pages, drawConfusion(inputFiles, regions, path.Base(dir))...)
+			}
+		} else {
+			pages = drawConfusion(flag.Args(), regions, "PandoraPFO")
+		}
+
+		if err := ana.SavePages(pages, ana.PlotWidth, ana.PlotHeight, *outputPath); err != nil {
+			log.Fatal(err)
+		}
+		if err := savedHists.Save(*outputPath); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *doResolution {
+		pages := newPFOResolutionPages()
+		if *inputsAreDirs {
+			for i, dir := range flag.Args() {
+				inputFiles, err := ana.DirFiles(dir)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				drawResolution(inputFiles, pages, ana.SetStyle(i), path.Base(dir))
+			}
+		} else {
+			drawResolution(flag.Args(), pages, ana.LineStyle{Color: ana.Blue}, "PandoraPFO")
+		}
+
+		drawers := make([]hplot.Drawer, len(pages))
+		for i, page := range pages {
+			drawers[i] = page
+		}
+		if err := ana.SavePages(drawers, resPageWidth, resPageHeight, *outputPath); err != nil {
+			log.Fatal(err)
+		}
+		if err := savedHists.Save(*outputPath); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *doElectronID {
+		interval, err := ana.ParseInterval(*intervalName)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		pages := newElectronIDPages()
+		var mapPages []hplot.Drawer
+		if *inputsAreDirs {
+			for i, dir := range flag.Args() {
+				inputFiles, err := ana.DirFiles(dir)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				mapPages = append(mapPages, drawElectronID(inputFiles, pages, ana.SetStyle(i), path.Base(dir), interval))
+			}
+		} else {
+			mapPages = append(mapPages, drawElectronID(flag.Args(), pages, ana.LineStyle{Color: ana.Blue}, "PandoraPFO", interval))
+		}
+
+		drawers := make([]hplot.Drawer, len(pages))
+		for i, page := range pages {
+			drawers[i] = page
+		}
+		drawers = append(drawers, mapPages...)
+		if err := ana.SavePages(drawers, elecPageWidth, elecPageHeight, *outputPath); err != nil {
+			log.Fatal(err)
+		}
+		if err := savedHists.Save(*outputPath); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *energyWeighted && *p_TWeighted {
+		log.Fatal("-e and -ptw are mutually exclusive")
+	}
+
+	title := "PFO/Truth Comparison"
+	yLabel := weightLabel()
+	if *normalize {
+		title = "PFO/Truth Ratio"
+		yLabel = "PFO / MCParticle"
+	} else if *inputsAreDirs {
+		title = "PFO Comparison"
+	}
+	p := ana.NewPlot(title, "eta", yLabel)
+
+	if *inputsAreDirs {
+		for i, dir := range flag.Args() {
+			inputFiles, err := ana.DirFiles(dir)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			var redTint uint8
+			style := ana.SetStyle(i)
+			if i == 1 {
+				redTint = 255
+			}
+
+			drawFileSet(inputFiles, p, false, redTint, path.Base(dir), style)
+		}
+	} else {
+		drawFileSet(flag.Args(), p, true, 0, "PandoraPFO", ana.LineStyle{})
+	}
+
+	if err := ana.SavePlot(p, *outputPath); err != nil {
+		log.Fatal(err)
+	}
+	if err := savedHists.Save(*outputPath); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// weightLabel returns the axis label of the distributions weighted as
+// selected by the -e and -ptw flags.
+func weightLabel() string {
+	switch {
+	case *energyWeighted:
+		return "energy {GeV}"
+	case *p_TWeighted:
+		return "p_T {GeV}"
+	}
+	return "count"
+}
+
+// etaHists accumulate the eta distributions of one worker.
+type etaHists struct {
+	chargedPFO, chargedTrue *hbook.H1D
+	neutralPFO, neutralTrue *hbook.H1D
+	typePFO, typeTrue       [nParticleTypes]*hbook.H1D
+}
+
+func newEtaHists() ana.Collector {
+	h := &etaHists{
+		chargedPFO:  hbook.NewH1D(nEtaBins, minEta, maxEta),
+		chargedTrue: hbook.NewH1D(nEtaBins, minEta, maxEta),
+		neutralPFO:  hbook.NewH1D(nEtaBins, minEta, maxEta),
+		neutralTrue: hbook.NewH1D(nEtaBins, minEta, maxEta),
+	}
+	for i := range h.typePFO {
+		h.typePFO[i] = hbook.NewH1D(nEtaBins, minEta, maxEta)
+		h.typeTrue[i] = hbook.NewH1D(nEtaBins, minEta, maxEta)
+	}
+	return h
+}
+
+func (h *etaHists) Collect(result interface{}) {
+	switch result := result.(type) {
+	case TrueResult:
+		if result.Charge != 0 {
+			h.chargedTrue.Fill(result.Eta, result.Weight)
+		} else {
+			h.neutralTrue.Fill(result.Eta, result.Weight)
+		}
+		h.typeTrue[result.Type].Fill(result.Eta, result.Weight)
+	case PFOResult:
+		if result.Charge != 0 {
+			h.chargedPFO.Fill(result.Eta, result.Weight)
+		} else {
+			h.neutralPFO.Fill(result.Eta, result.Weight)
+		}
+		h.typePFO[result.Type].Fill(result.Eta, result.Weight)
+	}
+}
+
+func (h *etaHists) Merge(other ana.Collector) {
+	o := other.(*etaHists)
+	ana.MergeH1Ds(
+		[]*hbook.H1D{h.chargedPFO, h.chargedTrue, h.neutralPFO, h.neutralTrue},
+		[]*hbook.H1D{o.chargedPFO, o.chargedTrue, o.neutralPFO, o.neutralTrue},
+	)
+	ana.MergeH1Ds(h.typePFO[:], o.typePFO[:])
+	ana.MergeH1Ds(h.typeTrue[:], o.typeTrue[:])
+}
+
+// save adds the histograms to savedHists under set, along with the ratios
+// drawn from them.
+func (h *etaHists) save(set string) {
+	yLabel := weightLabel()
+	savedHists.Add(set+"/chargedPFO", h.chargedPFO, "eta", yLabel)
+	savedHists.Add(set+"/chargedTrue", h.chargedTrue, "eta", yLabel)
+	savedHists.Add(set+"/neutralPFO", h.neutralPFO, "eta", yLabel)
+	savedHists.Add(set+"/neutralTrue", h.neutralTrue, "eta", yLabel)
+	savedHists.AddRatio(set+"/chargedRatio", set+"/chargedPFO", set+"/chargedTrue", "PFO / MCParticle")
+	savedHists.AddRatio(set+"/neutralRatio", set+"/neutralPFO", set+"/neutralTrue", "PFO / MCParticle")
+
+	for i := range h.typePFO {
+		name := particleTypeNames[i]
+		savedHists.Add(set+"/typePFO/"+name, h.typePFO[i], "eta", yLabel)
+		savedHists.Add(set+"/typeTrue/"+name, h.typeTrue[i], "eta", yLabel)
+		savedHists.AddRatio(set+"/typeRatio/"+name, set+"/typePFO/"+name, set+"/typeTrue/"+name, "PFO / MCParticle")
+	}
+}
+
+func drawFileSet(inputFiles []string, p *hplot.Plot, drawTruth bool, histRedTint uint8, histLabelPrefix string, histStyle ana.LineStyle) {
+	fileSet := ana.FileSet{Files: inputFiles, NThreads: *nThreads, MaxFiles: *maxFiles, MaxFailFrac: *maxFailFrac}
+	h := fileSet.Run(ctx, analyzeEvent, newEtaHists).(*etaHists)
+	h.save(histLabelPrefix)
+
+	if *perType {
+		// the types are told apart by color, so file sets are told apart by
+		// dash pattern alone
+		for i := ELEC; i < OTHER; i++ {
+			name := particleTypeNames[i]
+			histStyle.Color = particleTypeColors[i]
+
+			if *normalize {
+				hRatio := histStyle.NewErrorPlot(ana.Ratio(h.typePFO[i], h.typeTrue[i]))
+				p.Add(hRatio)
+				p.Legend.Add(histLabelPrefix+" "+name, hRatio)
+				continue
+			}
+
+			if drawTruth {
+				hTrue := hplot.NewH1D(h.typeTrue[i])
+				hTrue.LineStyle.Color = lighten(particleTypeColors[i])
+				hTrue.FillColor = nil
+				p.Add(hTrue)
+				p.Legend.Add("MCParticle "+name, hTrue)
+			}
+
+			hPFO := hplot.NewH1D(h.typePFO[i])
+			histStyle.Apply(hPFO)
+			hPFO.FillColor = nil
+			p.Add(hPFO)
+			p.Legend.Add(histLabelPrefix+" "+name, hPFO)
+		}
+		return
+	}
+
+	if *normalize {
+		histStyle.Color = color.RGBA{B: 255, A: 255, R: histRedTint}
+		hChargedRatio := histStyle.NewErrorPlot(ana.Ratio(h.chargedPFO, h.chargedTrue))
+		p.Add(hChargedRatio)
+		p.Legend.Add(histLabelPrefix+" Charged", hChargedRatio)
+
+		histStyle.Color = color.RGBA{G: 255, A: 255, R: histRedTint}
+		hNeutralRatio := histStyle.NewErrorPlot(ana.Ratio(h.neutralPFO, h.neutralTrue))
+		p.Add(hNeutralRatio)
+		p.Legend.Add(histLabelPrefix+" Neutral", hNeutralRatio)
+		return
+	}
+
+	if drawTruth {
+		hChargedTrue := hplot.NewH1D(h.chargedTrue)
+		hChargedTrue.LineStyle.Color = lighten(color.RGBA{B: 255, A: 255})
+		hChargedTrue.FillColor = nil
+		p.Add(hChargedTrue)
+		p.Legend.Add("MCParticle Charged", hChargedTrue)
+	}
+
+	hChargedPFO := hplot.NewH1D(h.chargedPFO)
+	histStyle.Color = color.RGBA{B: 255, A: 255, R: histRedTint}
+	histStyle.Apply(hChargedPFO)
+	hChargedPFO.FillColor = nil
+	p.Add(hChargedPFO)
+	p.Legend.Add(histLabelPrefix+" Charged", hChargedPFO)
+
+	if drawTruth {
+		hNeutralTrue := hplot.NewH1D(h.neutralTrue)
+		hNeutralTrue.LineStyle.Color = lighten(color.RGBA{G: 255, A: 255})
+		hNeutralTrue.FillColor = nil
+		p.Add(hNeutralTrue)
+		p.Legend.Add("MCParticle Neutral", hNeutralTrue)
+	}
+
+	hNeutralPFO := hplot.NewH1D(h.neutralPFO)
+	histStyle.Color = color.RGBA{G: 255, A: 255, R: histRedTint}
+	histStyle.Apply(hNeutralPFO)
+	hNeutralPFO.FillColor = nil
+	p.Add(hNeutralPFO)
+	p.Legend.Add(histLabelPrefix+" Neutral", hNeutralPFO)
+}
+
+// lighten returns c with each of its color components raised to at least 150,
+// for drawing truth distributions alongside their PFO counterparts.
+func lighten(c color.RGBA) color.RGBA {
+	const floor = 150
+	if c.R < floor {
+		c.R = floor
+	}
+	if c.G < floor {
+		c.G = floor
+	}
+	if c.B < floor {
+		c.B = floor
+	}
+	return c
+}
+
+func analyzeEvent(event *lcio.Event, out func(result interface{})) error {
+	truthColl, err := ana.MCParticles(event, *mcName)
+	if err != nil {
+		return err
+	}
+	pfoColl, err := ana.PFOs(event, *pfoName)
+	if err != nil {
+		return err
+	}
+
+	for _, truth := range truthColl.Particles {
+		if truth.GenStatus != 1 {
+			continue
+		}
+
+		p, energy := frame.FourMomentum(ana.Vec3(truth.P), truth.Energy())
+
+		out(TrueResult{truth.Charge, p.Eta(), particleTypeFromPDG(truth.PDG), resultWeight(p, energy)})
+	}
+
+	for _, pfo := range pfoColl.Parts {
+		p, energy := frame.FourMomentum(ana.Vec3From32(pfo.P), float64(pfo.Energy))
+
+		out(PFOResult{pfo.Charge, p.Eta(), particleTypeFromPDG(pfo.Type), resultWeight(p, energy)})
+	}
+	return nil
+}
+
+// resultWeight returns the weight of a particle with momentum p and the given
+// energy in the distributions, as selected by the -e and -ptw flags.
+func resultWeight(p ana.Vec3, energy float64) float64 {
+	switch {
+	case *energyWeighted:
+		return energy
+	case *p_TWeighted:
+		return p.Perp()
+	}
+	return 1
+}
+
+func particleTypeFromPDG(pdg int32) ParticleType {
+	absPDG := pdg
+	if absPDG < 0 {
+		absPDG = -absPDG
+	}
+
+	switch absPDG {
+	case 11:
+		return ELEC
+	case 111:
+		fallthrough
+	case 211:
+		return PION
+	case 2212:
+		return PROTON
+	case 22:
+		return PHOTON
+	case 2112:
+		return NEUTRON
+	}
+	return OTHER
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"go-hep.org/x/hep/lcio"
+
+	"github.com/decibelcooper/SiEIC/ana"
+)
+
+const (
+	maxMatchAngle      = 0.02
+	maxMatchEnergyFrac = 0.3
+	minMatchEnergy     = 0.5
+)
+
+// matchPFO returns the index of the PFO closest in direction to a truth
+// particle of momentum p and the given energy, among those within
+// maxMatchAngle of it and within the fraction maxEnergyFrac of its energy, or
+// -1 if there is none.  p, energy, pfoPs and pfoEnergies are all in the
+// analysis frame.  PFOs flagged in used, if it is not nil, are skipped.
+func matchPFO(p ana.Vec3, energy, maxEnergyFrac float64, pfoPs []ana.Vec3, pfoEnergies []float64, used []bool) int {
+	match := -1
+	minAngle := maxMatchAngle
+	for i, pfoEnergy := range pfoEnergies {
+		if used != nil && used[i] {
+			continue
+		}
+		if math.Abs(pfoEnergy-energy) > maxEnergyFrac*energy {
+			continue
+		}
+
+		angle := p.Angle(pfoPs[i])
+		if angle < minAngle {
+			minAngle = angle
+			match = i
+		}
+	}
+	return match
+}
+
+// matchEvent matches the final-state MCParticles of truthColl to the PFOs of
+// pfoColl one-to-one, most energetic first, allowing PFO energies to differ from the
+// truth by the fraction maxEnergyFrac.  It returns the MCParticles considered,
+// the index of the PFO matched to each or -1, and the PFO momenta in the
+// analysis frame.
+func matchEvent(truthColl *lcio.McParticleContainer, pfoColl *lcio.RecParticleContainer, maxEnergyFrac float64) ([]*lcio.McParticle, []int, []ana.Vec3) {
+	pfoPs := make([]ana.Vec3, len(pfoColl.Parts))
+	pfoEnergies := make([]float64, len(pfoColl.Parts))
+	for i, pfo := range pfoColl.Parts {
+		pfoPs[i], pfoEnergies[i] = frame.FourMomentum(ana.Vec3From32(pfo.P), float64(pfo.Energy))
+	}
+
+	var truths []*lcio.McParticle
+	for i, truth := range truthColl.Particles {
+		if truth.GenStatus != 1 || truth.Energy() < minMatchEnergy {
+			continue
+		}
+		switch truth.PDG {
+		case 12, -12, 14, -14, 16, -16:
+			continue
+		}
+		truths = append(truths, &truthColl.Particles[i])
+	}
+	sort.Slice(truths, func(i, j int) bool { return truths[i].Energy() > truths[j].Energy() })
+
+	matches := make([]int, len(truths))
+	used := make([]bool, len(pfoColl.Parts))
+	for i, truth := range truths {
+		p, energy := frame.FourMomentum(ana.Vec3(truth.P), truth.Energy())
+		matches[i] = matchPFO(p, energy, maxEnergyFrac, pfoPs, pfoEnergies, used)
+		if matches[i] >= 0 {
+			used[matches[i]] = true
+		}
+	}
+
+	return truths, matches, pfoPs
+}
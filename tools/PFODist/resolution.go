@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+
+	"go-hep.org/x/hep/hbook"
+	"go-hep.org/x/hep/hplot"
+	"go-hep.org/x/hep/lcio"
+
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+
+	"github.com/decibelcooper/SiEIC/ana"
+)
+
+const (
+	maxResMatchEnergyFrac = 1
+	maxResidual           = 1
+	nResidualBins         = 100
+	nResEnergyBins        = 10
+	nResEtaBins           = 10
+	minFitEntries         = 50
+	fitCoreNSigma         = 2
+	resPageWidth          = 10 * vg.Inch
+	resPageHeight         = 7 * vg.Inch
+)
+
+// resolutionClass groups MCParticles by the detector that measures them.
+type resolutionClass int
+
+const (
+	photonClass resolutionClass = iota
+	neutralHadronClass
+	chargedClass
+	nResolutionClasses
+)
+
+var (
+	resolutionClassNames = [nResolutionClasses]string{"Photon", "Neutral Hadron", "Charged"}
+	resolutionClassKeys  = [nResolutionClasses]string{"photon", "neutralHadron", "charged"}
+)
+
+// PFOResolutionResult holds the relative residual of the energy, or for
+// charged particles the momentum, of a PFO with respect to its matched
+// MCParticle.
+type PFOResolutionResult struct {
+	Class    resolutionClass
+	Eta      float64
+	Energy   float64
+	Residual float64
+}
+
+// newPFOResolutionPages returns one page per resolutionClass, each with tiles
+// for the residual, the resolution versus energy and eta, and the mean
+// response versus energy.
+func newPFOResolutionPages() []*hplot.TiledPlot {
+	var pages []*hplot.TiledPlot
+	for class, name := range resolutionClassNames {
+		page := hplot.NewTiledPlot(draw.Tiles{
+			Rows: 2,
+			Cols: 2,
+			PadX: 5 * vg.Millimeter,
+			PadY: 5 * vg.Millimeter,
+		})
+
+		variable := "E"
+		if resolutionClass(class) == chargedClass {
+			variable = "p"
+		}
+		residual := fmt.Sprintf("(%[1]v_PFO - %[1]v_true) / %[1]v_true", variable)
+		sigma := fmt.Sprintf("sigma(%[1]v) / %[1]v", variable)
+
+		labels := [][3]string{
+			{name + " Residual", residual, "count"},
+			{name + " Resolution vs. Energy", "true energy {GeV}", sigma},
+			{name + " Resolution vs. eta", "true eta", sigma},
+			{name + " Response vs. Energy", "true energy {GeV}", "mean " + residual},
+		}
+		for j, label := range labels {
+			*page.Plot(j%2, j/2) = *ana.NewPlot(label[0], label[1], label[2])
+		}
+
+		pages = append(pages, page)
+	}
+	return pages
+}
+
+// pfoResolutionHists accumulate the residuals of each resolutionClass for
+// one worker.
+type pfoResolutionHists struct {
+	residual [nResolutionClasses]*hbook.H1D
+	vsEnergy [nResolutionClasses][nResEnergyBins]*hbook.H1D
+	vsEta    [nResolutionClasses][nResEtaBins]*hbook.H1D
+}
+
+func newPFOResolutionHists() ana.Collector {
+	h := &pfoResolutionHists{}
+	for class := range h.residual {
+		h.residual[class] = hbook.NewH1D(nResidualBins, -maxResidual, maxResidual)
+		for i := range h.vsEnergy[class] {
+			h.vsEnergy[class][i] = hbook.NewH1D(nResidualBins, -maxResidual, maxResidual)
+		}
+		for i := range h.vsEta[class] {
+			h.vsEta[class][i] = hbook.NewH1D(nResidualBins, -maxResidual, maxResidual)
+		}
+	}
+	return h
+}
+
+func (h *pfoResolutionHists) Collect(result interface{}) {
+	switch result := result.(type) {
+	case PFOResolutionResult:
+		h.residual[result.Class].Fill(result.Residual, 1)
+
+		energyBin := int(math.Floor(nResEnergyBins * result.Energy / *resMaxEnergy))
+		if energyBin >= 0 && energyBin < nResEnergyBins {
+			h.vsEnergy[result.Class][energyBin].Fill(result.Residual, 1)
+		}
+		etaBin := int(math.Floor(nResEtaBins * (result.Eta - minEta) / (maxEta - minEta)))
+		if etaBin >= 0 && etaBin < nResEtaBins {
+			h.vsEta[result.Class][etaBin].Fill(result.Residual, 1)
+		}
+	}
+}
+
+func (h *pfoResolutionHists) Merge(other ana.Collector) {
+	o := other.(*pfoResolutionHists)
+	ana.MergeH1Ds(h.residual[:], o.residual[:])
+	for class := range h.vsEnergy {
+		ana.MergeH1Ds(h.vsEnergy[class][:], o.vsEnergy[class][:])
+		ana.MergeH1Ds(h.vsEta[class][:], o.vsEta[class][:])
+	}
+}
+
+// save adds the residual histograms of each class to savedHists under set.
+func (h *pfoResolutionHists) save(set string) {
+	for class, key := range resolutionClassKeys {
+		savedHists.Add(set+"/residual/"+key, h.residual[class], "relative residual", "count")
+		for i, hist := range h.vsEnergy[class] {
+			savedHists.Add(fmt.Sprintf("%v/residualVsEnergy/%v/%d", set, key, i), hist, "relative residual", "count")
+		}
+		for i, hist := range h.vsEta[class] {
+			savedHists.Add(fmt.Sprintf("%v/residualVsEta/%v/%d", set, key, i), hist, "relative residual", "count")
+		}
+	}
+}
+
+// drawResolution analyzes inputFiles and adds the resolution distributions to
+// the pages made by newPFOResolutionPages, with the resolution versus energy
+// fitted with stochastic and constant terms.
+func drawResolution(inputFiles []string, pages []*hplot.TiledPlot, style ana.LineStyle, label string) {
+	fileSet := ana.FileSet{Files: inputFiles, NThreads: *nThreads, MaxFiles: *maxFiles, MaxFailFrac: *maxFailFrac}
+	h := fileSet.Run(ctx, analyzeResolution, newPFOResolutionHists).(*pfoResolutionHists)
+	h.save(label)
+
+	for class, page := range pages {
+		hResidual := hplot.NewH1D(h.residual[class])
+		style.Apply(hResidual)
+		if !*inputsAreDirs {
+			hResidual.Infos.Style = hplot.HInfoSummary
+		}
+		page.Plot(0, 0).Add(hResidual)
+		page.Plot(0, 0).Legend.Add(label, hResidual)
+
+		response, vsEnergy := ana.CoreCurves(h.vsEnergy[class][:], 0, *resMaxEnergy, minFitEntries, fitCoreNSigma)
+		if vsEnergy.Len() > 0 {
+			page.Plot(1, 0).Add(style.NewErrorPlot(vsEnergy))
+			page.Plot(1, 1).Add(style.NewErrorPlot(response))
+		}
+
+		if resFit, err := ana.FitEnergyResolution(vsEnergy); err == nil {
+			ps := []float64{resFit.Stochastic, resFit.Constant}
+			f := hplot.NewFunction(func(e float64) float64 { return ana.EnergyResolution(e, ps) })
+			f.XMin = *resMaxEnergy / nResEnergyBins / 2
+			f.XMax = *resMaxEnergy
+			f.LineStyle.Color = style.Color
+			f.LineStyle.Dashes = style.Dashes
+			f.LineStyle.DashOffs = style.DashOffs
+			page.Plot(1, 0).Add(f)
+			page.Plot(1, 0).Legend.Add(fmt.Sprintf("%v: %.3f/sqrt(E) (+) %.3f", label, resFit.Stochastic, resFit.Constant), f)
+
+			log.Printf("%v %v resolution: stochastic %.4f +- %.4f, constant %.4f +- %.4f",
+				label, resolutionClassNames[class], resFit.Stochastic, resFit.StochasticErr, resFit.Constant, resFit.ConstantErr)
+		}
+
+		_, vsEta := ana.CoreCurves(h.vsEta[class][:], minEta, maxEta, minFitEntries, fitCoreNSigma)
+		if vsEta.Len() > 0 {
+			page.Plot(0, 1).Add(style.NewErrorPlot(vsEta))
+		}
+	}
+}
+
+// analyzeResolution sends the relative energy residual of each PFO matched to
+// a neutral MCParticle, and the relative momentum residual of each matched to
+// a charged one.
+func analyzeResolution(event *lcio.Event, out func(result interface{})) error {
+	truthColl, err := ana.MCParticles(event, *mcName)
+	if err != nil {
+		return err
+	}
+	pfoColl, err := ana.PFOs(event, *pfoName)
+	if err != nil {
+		return err
+	}
+	truths, matches, pfoPs := matchEvent(truthColl, pfoColl, maxResMatchEnergyFrac)
+
+	for i, truth := range truths {
+		match := matches[i]
+		if match < 0 {
+			continue
+		}
+
+		p, energy := frame.FourMomentum(ana.Vec3(truth.P), truth.Energy())
+		result := PFOResolutionResult{Eta: p.Eta(), Energy: energy}
+		switch {
+		case truth.Charge != 0:
+			result.Class = chargedClass
+			result.Residual = (pfoPs[match].Mag() - p.Mag()) / p.Mag()
+		default:
+			result.Class = neutralHadronClass
+			if particleTypeFromPDG(truth.PDG) == PHOTON {
+				result.Class = photonClass
+			}
+			pfo := &pfoColl.Parts[match]
+			_, pfoEnergy := frame.FourMomentum(ana.Vec3From32(pfo.P), float64(pfo.Energy))
+			result.Residual = (pfoEnergy - energy) / energy
+		}
+		out(result)
+	}
+	return nil
+}
@@ -1,42 +1,150 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"image/color"
-	"io/ioutil"
 	"log"
 	"math"
 	"os"
 	"path"
-	"time"
+	"sort"
+	"strings"
 
 	"go-hep.org/x/hep/hbook"
 	"go-hep.org/x/hep/hplot"
 	"go-hep.org/x/hep/lcio"
 
 	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+
+	"github.com/decibelcooper/SiEIC/ana"
+)
+
+var (
+	doMaps              = flag.Bool("map", false, "plot eta-phi maps of cluster count and energy, and cluster shape distributions, per input set")
+	doResponse          = flag.Bool("resp", false, "plot cluster energy response to the matched MCParticles vs. eta for EM and hadronic showers")
+	doSubDetectors      = flag.Bool("subdet", false, "plot the breakdown of cluster energy by calorimeter sub-detector vs. eta, per input set")
+	clusterName         = flag.String("clusters", ana.DefaultClusters, "name of the Cluster collection")
+	crossingAngleSource = flag.String("xangle", ana.DefaultCrossingAngleSource, "compact detector description or SLIC macro defining the crossing angle")
+	frameName           = flag.String("frame", "lab", "frame in which to histogram kinematics: lab, or headon to remove the crossing-angle boost")
+	energyWeighted      = flag.Bool("e", false, "weight distribution by energy")
+	inputsAreDirs       = flag.Bool("d", false, "inputs are directories")
+	matchMode           = flag.String("match", "angle", "cluster-to-MCParticle matching: angle (neutral particles pointing at the cluster) or hits (calorimeter hit truth contributions)")
+	maxFailFrac         = flag.Float64("maxfail", 0, "fraction of input files that may fail to be read before exiting with status 1")
+	maxFiles            = flag.Int("m", math.MaxInt32, "maximum number of files to process")
+	mcName              = flag.String("mc", ana.DefaultMCParticles, "name of the MCParticle collection")
+	nThreads            = flag.Int("t", 2, "number of concurrent files to process")
+	outputPath          = flag.String("o", "out.pdf", "path of output file")
+	relationNames       = flag.String("rel", strings.Join(ana.DefaultCalorimeterHitRelations, ","), "comma-separated LCRelation collections followed in hits matching")
+	relative            = flag.Bool("r", false, "plot input directories relative to the first")
 )
 
 var (
-	energyWeighted = flag.Bool("e", false, "weight distribution by energy")
-	inputsAreDirs  = flag.Bool("d", false, "inputs are directories")
-	maxFiles       = flag.Int("m", math.MaxInt32, "maximum number of files to process")
-	nThreads       = flag.Int("t", 2, "number of concurrent files to process")
-	outputPath     = flag.String("o", "out.pdf", "path of output file")
+	ctx        context.Context
+	frame      ana.Frame
+	savedHists ana.Hists
 )
 
 const (
 	minEta   = -5
 	maxEta   = 5
 	nEtaBins = 50
+
+	minTruthEnergy = 0.5
+	maxMatchAngle  = 0.05
+	maxResponse    = 2
+	nResponseBins  = 100
+	nRespEtaBins   = 10
+	minFitEntries  = 50
+	fitCoreNSigma  = 2
+	respPageWidth  = 10 * vg.Inch
+	respPageHeight = 7 * vg.Inch
+
+	nMapEtaBins    = 50
+	nMapPhiBins    = 64
+	nShapeBins     = 50
+	shapePageCols  = 3
+	shapeTailFrac  = 0.005
+	shapePageWidth = 10 * vg.Inch
 )
 
+// subDetectorColors fill the stacked histograms of each calorimeter in
+// sub-detector mode.
+var subDetectorColors = map[ana.SubDetector]color.RGBA{
+	ana.EcalBarrel: {B: 255, A: 255},
+	ana.EcalEndcap: {R: 120, G: 120, B: 255, A: 255},
+	ana.HcalBarrel: {R: 255, A: 255},
+	ana.HcalEndcap: {R: 255, G: 140, B: 140, A: 255},
+	ana.MuonBarrel: {G: 160, A: 255},
+	ana.MuonEndcap: {R: 120, G: 230, B: 120, A: 255},
+	ana.LumiCal:    {R: 255, G: 140, A: 255},
+	ana.BeamCal:    {R: 160, B: 160, A: 255},
+}
+
 type clusterResult struct {
 	Eta    float64
 	Energy float64
 }
 
+// ClusterShapeResult describes the position, energy, hit count, intrinsic
+// direction and shape parameters of a cluster.
+type ClusterShapeResult struct {
+	Eta    float64
+	Phi    float64
+	Energy float64
+	NHits  int
+	ITheta float64
+	IPhi   float64
+	Shape  []float32
+}
+
+// ShapeNamesResult holds the names of the cluster shape parameters, from the
+// ShapeParameterNames parameter of the cluster collection.
+type ShapeNamesResult []string
+
+// SubDetectorResult holds the energy of a cluster in each calorimeter,
+// indexed by ana.SubDetector.
+type SubDetectorResult struct {
+	Eta      float64
+	Energies [ana.NSubDetectors]float64
+}
+
+// SkippedClusterResult reports a cluster left out of the sub-detector
+// breakdown, and why.
+type SkippedClusterResult struct {
+	Reason error
+}
+
+// Reasons for which clusters are left out of the sub-detector breakdown,
+// which are counted and reported per input set.
+var (
+	errNoCalorimeterEnergy = errors.New("no energy in the calorimeters from SubDetEnes or hits")
+	errExtraSubDetEnergies = errors.New("more SubDetEnes than known sub-detectors")
+	errUnnamedSubDetEnes   = errors.New("SubDetEnes without " + ana.ClusterSubDetectorNames + " parameter, and no hits")
+)
+
+// showerType is the kind of shower a matched MCParticle is expected to make.
+type showerType int
+
+const (
+	emShower showerType = iota
+	hadronicShower
+	nShowerTypes
+)
+
+var showerTypeNames = [nShowerTypes]string{"EM", "Hadronic"}
+
+// ResponseResult holds the ratio of the energy of the clusters matched to an
+// MCParticle to the energy of the MCParticle.
+type ResponseResult struct {
+	Shower   showerType
+	Eta      float64
+	Response float64
+}
+
 func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, `Usage: clusterDist [options] <lcio-input-file>
@@ -47,159 +155,745 @@ options:
 	}
 
 	flag.Parse()
+	defer ana.ExitIfFailed()
+	ctx = ana.InterruptContext()
 
-	p := hplot.New()
+	var err error
+	frame, err = ana.NewFrame(*frameName, *crossingAngleSource)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	p.Title.Text = "Cluster Distribution"
-	p.Title.Padding = 2 * vg.Millimeter
-	p.Legend.Left = true
-	p.Legend.Top = true
-	p.Legend.Padding = 2 * vg.Millimeter
-	p.X.Label.Text = "eta"
+	if *doMaps {
+		var pages []hplot.Drawer
+		if *inputsAreDirs {
+			for _, dir := range flag.Args() {
+				inputFiles, err := ana.DirFiles(dir)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				pages = append(pages, drawMaps(inputFiles, path.Base(dir))...)
+			}
+		} else {
+			pages = drawMaps(flag.Args(), "")
+		}
+
+		if err := ana.SavePages(pages, shapePageWidth, respPageHeight, *outputPath); err != nil {
+			log.Fatal(err)
+		}
+		if err := savedHists.Save(*outputPath); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *doSubDetectors {
+		var pages []hplot.Drawer
+		if *inputsAreDirs {
+			for _, dir := range flag.Args() {
+				inputFiles, err := ana.DirFiles(dir)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				pages = append(pages, drawSubDetectors(inputFiles, path.Base(dir)))
+			}
+		} else {
+			pages = append(pages, drawSubDetectors(flag.Args(), ""))
+		}
+
+		if err := ana.SavePages(pages, shapePageWidth, respPageHeight, *outputPath); err != nil {
+			log.Fatal(err)
+		}
+		if err := savedHists.Save(*outputPath); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *doResponse {
+		switch *matchMode {
+		case "angle", "hits":
+		default:
+			log.Fatalf("unknown matching mode %q", *matchMode)
+		}
+
+		page := newResponsePage()
+		if *inputsAreDirs {
+			for i, dir := range flag.Args() {
+				inputFiles, err := ana.DirFiles(dir)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				drawResponse(inputFiles, page, ana.SetStyle(i), path.Base(dir))
+			}
+		} else {
+			drawResponse(flag.Args(), page, ana.LineStyle{Color: ana.Blue}, "ReconClusters")
+		}
+
+		if err := ana.SavePages([]hplot.Drawer{page}, respPageWidth, respPageHeight, *outputPath); err != nil {
+			log.Fatal(err)
+		}
+		if err := savedHists.Save(*outputPath); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	yLabel := "count"
 	if *energyWeighted {
-		p.Y.Label.Text = "energy (arb)"
-	} else {
-		p.Y.Label.Text = "count"
+		yLabel = "energy (arb)"
 	}
+	title := "Cluster Distribution"
+	if *relative {
+		if !*inputsAreDirs || flag.NArg() < 2 {
+			log.Fatal("-r requires -d and at least two input directories")
+		}
+		title = "Cluster Distribution Ratio"
+		yLabel = "ratio to " + path.Base(flag.Arg(0))
+	}
+	p := ana.NewPlot(title, "eta", yLabel)
 
 	if *inputsAreDirs {
+		var refHist *hbook.H1D
 		for i, dir := range flag.Args() {
-			files, err := ioutil.ReadDir(dir)
+			inputFiles, err := ana.DirFiles(dir)
 			if err != nil {
 				log.Fatal(err)
 			}
 
-			var inputFiles []string
-			for _, file := range files {
-				inputFiles = append(inputFiles, dir+"/"+file.Name())
-			}
-
-			histColor := color.RGBA{R: 255, B: 255, G: 255, A: 255}
-			var dashes []vg.Length
-			var dashOffs vg.Length
-			switch i {
-			case 0:
-				histColor = color.RGBA{B: 255, A: 255}
-			case 1:
-				histColor = color.RGBA{R: 255, A: 255}
-				dashes = append(dashes, 1*vg.Millimeter)
-			case 2:
-				histColor = color.RGBA{G: 255, A: 255}
-				dashes = append(dashes, 1*vg.Millimeter)
-				dashOffs = 1 * vg.Millimeter
+			hist := drawFileSet(inputFiles, p, ana.SetStyle(i), path.Base(dir), refHist)
+			if *relative && i == 0 {
+				refHist = hist
 			}
-
-			drawFileSet(inputFiles, p, histColor, path.Base(dir), dashes, dashOffs)
 		}
 	} else {
-		drawFileSet(flag.Args(), p, color.RGBA{B: 255, A: 255}, "", nil, 0)
+		drawFileSet(flag.Args(), p, ana.LineStyle{Color: ana.Blue}, "", nil)
 	}
 
-	p.Save(6*vg.Inch, 4*vg.Inch, *outputPath)
+	if err := ana.SavePlot(p, *outputPath); err != nil {
+		log.Fatal(err)
+	}
+	if err := savedHists.Save(*outputPath); err != nil {
+		log.Fatal(err)
+	}
 }
 
-func drawFileSet(inputFiles []string, p *hplot.Plot, histColor color.Color, histLabel string, histDashes []vg.Length, histDashOffs vg.Length) {
-	clusterEtaHist := hbook.NewH1D(nEtaBins, minEta, maxEta)
+// saveSet returns the name of the input set under which the histograms of
+// label are saved: label itself, or ReconClusters without -d.
+func saveSet(label string) string {
+	if label == "" {
+		return "ReconClusters"
+	}
+	return label
+}
 
-	clusterOut := make(chan clusterResult)
-	done := make(chan bool)
+// etaHist accumulates the cluster eta distribution of one worker.
+type etaHist struct {
+	*hbook.H1D
+}
 
-	nFilesToAnalyze := len(inputFiles)
-	if *maxFiles < nFilesToAnalyze {
-		nFilesToAnalyze = *maxFiles
+func newEtaHist() ana.Collector {
+	return etaHist{hbook.NewH1D(nEtaBins, minEta, maxEta)}
+}
+
+func (h etaHist) Collect(result interface{}) {
+	if result, ok := result.(clusterResult); ok {
+		h.Fill(result.Eta, result.Energy)
 	}
+}
 
-	nSubmitted := 0
-	nDone := 0
+func (h etaHist) Merge(other ana.Collector) {
+	ana.MergeH1D(h.H1D, other.(etaHist).H1D)
+}
 
-	for nSubmitted < nFilesToAnalyze && nSubmitted < *nThreads {
-		go analyzeFile(inputFiles[nSubmitted], clusterOut, done)
-		nSubmitted++
+// drawFileSet fills the cluster distribution of inputFiles and draws it, or
+// its ratio to refHist if one is given, returning the filled histogram.
+func drawFileSet(inputFiles []string, p *hplot.Plot, histStyle ana.LineStyle, histLabel string, refHist *hbook.H1D) *hbook.H1D {
+	fileSet := ana.FileSet{Files: inputFiles, NThreads: *nThreads, MaxFiles: *maxFiles, MaxFailFrac: *maxFailFrac}
+	clusterEtaHist := fileSet.Run(ctx, analyzeEvent, newEtaHist).(etaHist).H1D
 
-		time.Sleep(time.Millisecond)
+	set := saveSet(histLabel)
+	yLabel := "count"
+	if *energyWeighted {
+		yLabel = "energy (arb)"
 	}
+	savedHists.Add(set+"/clusterEta", clusterEtaHist, "eta", yLabel)
 
-	for nDone < nSubmitted {
-		select {
-		case result := <-clusterOut:
-			clusterEtaHist.Fill(result.Eta, result.Energy)
-		case isDone := <-done:
-			if isDone {
-				nDone++
+	if *relative {
+		if refHist != nil {
+			refSet := path.Base(flag.Arg(0))
+			savedHists.AddRatio(set+"/ratio", set+"/clusterEta", refSet+"/clusterEta", "ratio to "+refSet)
 
-				if nSubmitted < nFilesToAnalyze {
-					go analyzeFile(inputFiles[nSubmitted], clusterOut, done)
-					nSubmitted++
-				}
-			}
+			hRatio := histStyle.NewErrorPlot(ana.Ratio(clusterEtaHist, refHist))
+			p.Add(hRatio)
+			p.Legend.Add(histLabel, hRatio)
 		}
+		return clusterEtaHist
 	}
 
 	hCluster := hplot.NewH1D(clusterEtaHist)
-	hCluster.LineStyle.Color = histColor
-	hCluster.LineStyle.Dashes = histDashes
-	hCluster.LineStyle.DashOffs = histDashOffs
+	histStyle.Apply(hCluster)
 	p.Add(hCluster)
 	if *inputsAreDirs {
 		p.Legend.Add(histLabel, hCluster)
 	}
+	return clusterEtaHist
+}
+
+// mapHists accumulate the cluster maps and shapes of one worker.
+type mapHists struct {
+	countMap, energyMap *hbook.H2D
+	nHits, iTheta, iPhi *hbook.H1D
+
+	shapeNames []string
+	// the ranges of the shape parameters are not known in advance, so their
+	// values are kept until all events are analyzed
+	shapeValues [][]float64
+}
+
+func newMapHists() ana.Collector {
+	return &mapHists{
+		countMap:  hbook.NewH2D(nMapEtaBins, minEta, maxEta, nMapPhiBins, -math.Pi, math.Pi),
+		energyMap: hbook.NewH2D(nMapEtaBins, minEta, maxEta, nMapPhiBins, -math.Pi, math.Pi),
+		nHits:     hbook.NewH1D(nShapeBins, 0, nShapeBins*4),
+		iTheta:    hbook.NewH1D(nShapeBins, 0, math.Pi),
+		iPhi:      hbook.NewH1D(nShapeBins, -math.Pi, math.Pi),
+	}
+}
+
+func (h *mapHists) Collect(result interface{}) {
+	switch result := result.(type) {
+	case ShapeNamesResult:
+		if h.shapeNames == nil {
+			h.shapeNames = result
+		}
+	case ClusterShapeResult:
+		h.countMap.Fill(result.Eta, result.Phi, 1)
+		h.energyMap.Fill(result.Eta, result.Phi, result.Energy)
+		h.nHits.Fill(float64(result.NHits), 1)
+		h.iTheta.Fill(result.ITheta, 1)
+		h.iPhi.Fill(result.IPhi, 1)
+
+		for len(h.shapeValues) < len(result.Shape) {
+			h.shapeValues = append(h.shapeValues, nil)
+		}
+		for i, value := range result.Shape {
+			h.shapeValues[i] = append(h.shapeValues[i], float64(value))
+		}
+	}
+}
+
+func (h *mapHists) Merge(other ana.Collector) {
+	o := other.(*mapHists)
+	ana.MergeH2D(h.countMap, o.countMap)
+	ana.MergeH2D(h.energyMap, o.energyMap)
+	ana.MergeH1Ds([]*hbook.H1D{h.nHits, h.iTheta, h.iPhi}, []*hbook.H1D{o.nHits, o.iTheta, o.iPhi})
+
+	if h.shapeNames == nil {
+		h.shapeNames = o.shapeNames
+	}
+	for len(h.shapeValues) < len(o.shapeValues) {
+		h.shapeValues = append(h.shapeValues, nil)
+	}
+	for i, values := range o.shapeValues {
+		h.shapeValues[i] = append(h.shapeValues[i], values...)
+	}
 }
 
-func analyzeFile(inputPath string, clusterOut chan<- clusterResult, done chan<- bool) {
-	reader, err := lcio.Open(inputPath)
+// drawMaps analyzes inputFiles and returns a page of eta-phi maps of cluster
+// count and energy, and a page of cluster hit count, intrinsic direction and
+// shape parameter distributions.  label is appended to the titles.
+func drawMaps(inputFiles []string, label string) []hplot.Drawer {
+	fileSet := ana.FileSet{Files: inputFiles, NThreads: *nThreads, MaxFiles: *maxFiles, MaxFailFrac: *maxFailFrac}
+	hists := fileSet.Run(ctx, analyzeShapes, newMapHists).(*mapHists)
+
+	set := saveSet(label)
+	savedHists.Add(set+"/countMap", hists.countMap, "eta", "phi")
+	savedHists.Add(set+"/energyMap", hists.energyMap, "eta", "phi")
+
+	if label != "" {
+		label = ": " + label
+	}
+
+	mapPage := hplot.NewTiledPlot(draw.Tiles{
+		Rows: 1,
+		Cols: 2,
+		PadX: 5 * vg.Millimeter,
+	})
+	maps := []struct {
+		title string
+		hist  *hbook.H2D
+	}{
+		{"Cluster Count", hists.countMap},
+		{"Cluster Energy", hists.energyMap},
+	}
+	for i, m := range maps {
+		tile := mapPage.Plot(i, 0)
+		*tile = *ana.NewPlot(m.title+label, "eta", "phi")
+		h := hplot.NewH2D(m.hist, nil)
+		tile.Add(h)
+	}
+
+	type shapeHist struct {
+		title, xLabel string
+		hist          *hbook.H1D
+	}
+	shapeHists := []shapeHist{
+		{"Hits per Cluster", "number of hits", hists.nHits},
+		{"Cluster Intrinsic Theta", "ITheta", hists.iTheta},
+		{"Cluster Intrinsic Phi", "IPhi", hists.iPhi},
+	}
+	for i, values := range hists.shapeValues {
+		name := fmt.Sprintf("shape[%d]", i)
+		if i < len(hists.shapeNames) {
+			name = hists.shapeNames[i]
+		}
+		shapeHists = append(shapeHists, shapeHist{"Cluster " + name, name, rangedHist(values)})
+	}
+
+	shapePage := hplot.NewTiledPlot(draw.Tiles{
+		Rows: (len(shapeHists) + shapePageCols - 1) / shapePageCols,
+		Cols: shapePageCols,
+		PadX: 5 * vg.Millimeter,
+		PadY: 5 * vg.Millimeter,
+	})
+	shapeKeys := []string{"nHits", "iTheta", "iPhi"}
+	for i, sh := range shapeHists {
+		key := "shape/" + sh.xLabel
+		if i < len(shapeKeys) {
+			key = shapeKeys[i]
+		}
+		savedHists.Add(set+"/"+key, sh.hist, sh.xLabel, "count")
+
+		tile := shapePage.Plot(i%shapePageCols, i/shapePageCols)
+		*tile = *ana.NewPlot(sh.title+label, sh.xLabel, "count")
+		h := hplot.NewH1D(sh.hist)
+		ana.LineStyle{Color: ana.Blue}.Apply(h)
+		tile.Add(h)
+	}
+
+	return []hplot.Drawer{mapPage, shapePage}
+}
+
+// rangedHist returns a histogram of values spanning all but the fraction
+// shapeTailFrac of them in each tail.
+func rangedHist(values []float64) *hbook.H1D {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	min, max := 0., 1.
+	if n := len(sorted); n > 0 {
+		min = sorted[int(shapeTailFrac*float64(n-1))]
+		max = sorted[int((1-shapeTailFrac)*float64(n-1))]
+	}
+	if max <= min {
+		max = min + 1
+	}
+
+	h := hbook.NewH1D(nShapeBins, min, max)
+	for _, value := range values {
+		h.Fill(value, 1)
+	}
+	return h
+}
+
+func analyzeShapes(event *lcio.Event, out func(result interface{})) error {
+	clusterColl, err := ana.Clusters(event, *clusterName)
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	if names := clusterColl.Params.Strings["ShapeParameterNames"]; len(names) > 0 {
+		out(ShapeNamesResult(names))
+	}
+
+	for _, cluster := range clusterColl.Clusters {
+		dir := frame.Direction(ana.Vec3From32(cluster.Pos))
+
+		out(ClusterShapeResult{
+			Eta:    dir.Eta(),
+			Phi:    dir.Phi(),
+			Energy: float64(cluster.Energy),
+			NHits:  len(cluster.Hits),
+			ITheta: float64(cluster.Theta),
+			IPhi:   float64(cluster.Phi),
+			Shape:  cluster.Shape,
+		})
+	}
+	return nil
+}
+
+// subDetectorHists accumulate the cluster energy vs. eta of each calorimeter
+// for one worker, indexed by ana.SubDetector, and count the clusters skipped
+// for each reason.
+type subDetectorHists struct {
+	energies  [ana.NSubDetectors]*hbook.H1D
+	nClusters int
+	skipped   map[string]int
+}
+
+func newSubDetectorHists() ana.Collector {
+	hists := &subDetectorHists{skipped: make(map[string]int)}
+	for _, subDet := range ana.Calorimeters {
+		hists.energies[subDet] = hbook.NewH1D(nEtaBins, minEta, maxEta)
+	}
+	return hists
+}
+
+func (hists *subDetectorHists) Collect(result interface{}) {
+	switch result := result.(type) {
+	case SubDetectorResult:
+		hists.nClusters++
+		for _, subDet := range ana.Calorimeters {
+			hists.energies[subDet].Fill(result.Eta, result.Energies[subDet])
+		}
+	case SkippedClusterResult:
+		hists.nClusters++
+		hists.skipped[result.Reason.Error()]++
+	}
+}
+
+func (hists *subDetectorHists) Merge(other ana.Collector) {
+	o := other.(*subDetectorHists)
+	for _, subDet := range ana.Calorimeters {
+		ana.MergeH1D(hists.energies[subDet], o.energies[subDet])
+	}
+	hists.nClusters += o.nClusters
+	for reason, n := range o.skipped {
+		hists.skipped[reason] += n
+	}
+}
+
+// reportSkipped logs the number of clusters of the input set with the given
+// label, if any, that were skipped for each reason.
+func (hists *subDetectorHists) reportSkipped(label string) {
+	reasons := make([]string, 0, len(hists.skipped))
+	for reason := range hists.skipped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	if label != "" {
+		label += ": "
+	}
+	for _, reason := range reasons {
+		log.Printf("%vskipped %v of %v clusters: %v", label, hists.skipped[reason], hists.nClusters, reason)
+	}
+}
+
+// drawSubDetectors analyzes inputFiles and returns a page of the cluster
+// energy in each calorimeter vs. eta, stacked, both summed and as a fraction
+// of the total in each eta bin.  label is appended to the titles.
+func drawSubDetectors(inputFiles []string, label string) hplot.Drawer {
+	fileSet := ana.FileSet{Files: inputFiles, NThreads: *nThreads, MaxFiles: *maxFiles, MaxFailFrac: *maxFailFrac}
+	hists := fileSet.Run(ctx, analyzeSubDetectors, newSubDetectorHists).(*subDetectorHists)
+	hists.reportSkipped(label)
+	energyHists := hists.energies
+	for _, subDet := range ana.Calorimeters {
+		savedHists.Add(saveSet(label)+"/energy/"+subDet.String(), energyHists[subDet], "eta", "energy {GeV}")
 	}
-	defer reader.Close()
 
-	for reader.Next() {
-		event := reader.Event()
+	if label != "" {
+		label = ": " + label
+	}
 
-		clusterColl := event.Get("ReconClusters").(*lcio.ClusterContainer)
+	totals := make([]float64, nEtaBins)
+	for _, subDet := range ana.Calorimeters {
+		for i, bin := range energyHists[subDet].Binning.Bins {
+			totals[i] += bin.SumW()
+		}
+	}
+
+	var fracHists [ana.NSubDetectors]*hbook.H1D
+	for _, subDet := range ana.Calorimeters {
+		fracHists[subDet] = hbook.NewH1D(nEtaBins, minEta, maxEta)
+		for i, bin := range energyHists[subDet].Binning.Bins {
+			if totals[i] > 0 {
+				fracHists[subDet].Fill(bin.XMid(), bin.SumW()/totals[i])
+			}
+		}
+	}
+
+	page := hplot.NewTiledPlot(draw.Tiles{
+		Rows: 1,
+		Cols: 2,
+		PadX: 5 * vg.Millimeter,
+	})
+	stacks := []struct {
+		title, yLabel string
+		hists         [ana.NSubDetectors]*hbook.H1D
+	}{
+		{"Cluster Energy by Sub-detector", "energy {GeV}", energyHists},
+		{"Fraction of Cluster Energy by Sub-detector", "fraction of energy", fracHists},
+	}
+	for i, stack := range stacks {
+		tile := page.Plot(i, 0)
+		*tile = *ana.NewPlot(stack.title+label, "eta", stack.yLabel)
+		tile.Legend.Left = false
+
+		var hists []*hplot.H1D
+		for _, subDet := range ana.Calorimeters {
+			h := hplot.NewH1D(stack.hists[subDet])
+			h.LineStyle.Color = subDetectorColors[subDet]
+			h.FillColor = subDetectorColors[subDet]
+			hists = append(hists, h)
+			tile.Legend.Add(subDet.String(), h)
+		}
+		tile.Add(hplot.NewHStack(hists))
+	}
+	return page
+}
+
+// analyzeSubDetectors divides the energy of each cluster among the
+// calorimeters in proportion to its SubDetEnes, indexed as given by
+// ana.ClusterSubDetectors.  Clusters without SubDetEnes, or all clusters if
+// the collection does not name the sub-detectors they index, are divided in
+// proportion to the energy of their hits in each calorimeter, identified by
+// their cell IDs, and those with neither are skipped.
+func analyzeSubDetectors(event *lcio.Event, out func(result interface{})) error {
+	clusterColl, err := ana.Clusters(event, *clusterName)
+	if err != nil {
+		return err
+	}
+	subDets, err := ana.ClusterSubDetectors(clusterColl)
+	useSubDetEnes := err == nil
+	if err != nil && err != ana.ErrNoClusterSubDetectors {
+		return err
+	}
+
+	for _, cluster := range clusterColl.Clusters {
+		var result SubDetectorResult
+		var sumEnergy float64
+		if useSubDetEnes {
+			if len(cluster.SubDetEnes) > len(subDets) {
+				out(SkippedClusterResult{errExtraSubDetEnergies})
+				continue
+			}
+			for i, energy := range cluster.SubDetEnes {
+				result.Energies[subDets[i]] += float64(energy)
+				sumEnergy += float64(energy)
+			}
+		}
+		if !useSubDetEnes || len(cluster.SubDetEnes) == 0 {
+			for _, hit := range cluster.Hits {
+				subDet := ana.HitSubDetector(hit.CellID0)
+				if subDet < ana.EcalBarrel || subDet >= ana.NSubDetectors {
+					continue
+				}
 
-		for _, cluster := range clusterColl.Clusters {
-			pNorm := normalizePos(cluster.Pos)
-			eta := math.Atanh(pNorm[2])
-			energy := 1.
-			if *energyWeighted {
-				energy = float64(cluster.Energy)
+				result.Energies[subDet] += float64(hit.Energy)
+				sumEnergy += float64(hit.Energy)
+			}
+		}
+		if sumEnergy <= 0 {
+			if !useSubDetEnes && len(cluster.SubDetEnes) > 0 {
+				out(SkippedClusterResult{errUnnamedSubDetEnes})
+			} else {
+				out(SkippedClusterResult{errNoCalorimeterEnergy})
 			}
+			continue
+		}
 
-			result := clusterResult{eta, energy}
-			clusterOut <- result
+		for i := range result.Energies {
+			result.Energies[i] *= float64(cluster.Energy) / sumEnergy
 		}
+		result.Eta = frame.Direction(ana.Vec3From32(cluster.Pos)).Eta()
+		out(result)
+	}
+	return nil
+}
+
+func analyzeEvent(event *lcio.Event, out func(result interface{})) error {
+	clusterColl, err := ana.Clusters(event, *clusterName)
+	if err != nil {
+		return err
 	}
 
-	done <- true
+	for _, cluster := range clusterColl.Clusters {
+		eta := frame.Direction(ana.Vec3From32(cluster.Pos)).Eta()
+		energy := 1.
+		if *energyWeighted {
+			energy = float64(cluster.Energy)
+		}
+
+		out(clusterResult{eta, energy})
+	}
+	return nil
 }
 
-func normalizePos(vector [3]float32) (retVec [3]float64) {
-	normFactor := math.Sqrt(dotProduct32(vector, vector))
-	for i, value := range vector {
-		retVec[i] = float64(value) / normFactor
+// newResponsePage returns a page with tiles for the response distribution and
+// the mean response versus eta of each showerType.
+func newResponsePage() *hplot.TiledPlot {
+	page := hplot.NewTiledPlot(draw.Tiles{
+		Rows: 2,
+		Cols: 2,
+		PadX: 5 * vg.Millimeter,
+		PadY: 5 * vg.Millimeter,
+	})
+
+	for i, name := range showerTypeNames {
+		*page.Plot(i, 0) = *ana.NewPlot(name+" Cluster Response", "E_cluster / E_true", "count")
+		*page.Plot(i, 1) = *ana.NewPlot(name+" Cluster Response vs. eta", "true eta", "mean E_cluster / E_true")
 	}
-	return
+	return page
+}
+
+// responseHists accumulate the cluster response of each showerType for one
+// worker.
+type responseHists struct {
+	response [nShowerTypes]*hbook.H1D
+	vsEta    [nShowerTypes][nRespEtaBins]*hbook.H1D
 }
 
-func normalizeVector(vector [3]float64) [3]float64 {
-	normFactor := math.Sqrt(dotProduct(vector, vector))
-	for i, value := range vector {
-		vector[i] = value / normFactor
+func newResponseHists() ana.Collector {
+	h := &responseHists{}
+	for shower := range h.response {
+		h.response[shower] = hbook.NewH1D(nResponseBins, 0, maxResponse)
+		for i := range h.vsEta[shower] {
+			h.vsEta[shower][i] = hbook.NewH1D(nResponseBins, 0, maxResponse)
+		}
 	}
-	return vector
+	return h
 }
 
-func phiFromVector(vector [3]float64) float64 {
-	rho := math.Sqrt(vector[0]*vector[0] + vector[1]*vector[1])
-	if vector[0] >= 0 {
-		return math.Asin(vector[1] / rho)
+func (h *responseHists) Collect(result interface{}) {
+	switch result := result.(type) {
+	case ResponseResult:
+		h.response[result.Shower].Fill(result.Response, 1)
+
+		etaBin := int(math.Floor(nRespEtaBins * (result.Eta - minEta) / (maxEta - minEta)))
+		if etaBin >= 0 && etaBin < nRespEtaBins {
+			h.vsEta[result.Shower][etaBin].Fill(result.Response, 1)
+		}
 	}
-	return -math.Asin(vector[1]/rho) + math.Pi
 }
 
-func dotProduct32(vector1 [3]float32, vector2 [3]float32) float64 {
-	return float64(vector1[0]*vector2[0] + vector1[1]*vector2[1] + vector1[2]*vector2[2])
+func (h *responseHists) Merge(other ana.Collector) {
+	o := other.(*responseHists)
+	ana.MergeH1Ds(h.response[:], o.response[:])
+	for shower := range h.vsEta {
+		ana.MergeH1Ds(h.vsEta[shower][:], o.vsEta[shower][:])
+	}
+}
+
+// drawResponse analyzes inputFiles and adds the response distributions to the
+// page made by newResponsePage, with the mean response versus eta taken from
+// Gaussian fits to the response cores.
+func drawResponse(inputFiles []string, page *hplot.TiledPlot, style ana.LineStyle, label string) {
+	fileSet := ana.FileSet{Files: inputFiles, NThreads: *nThreads, MaxFiles: *maxFiles, MaxFailFrac: *maxFailFrac}
+	hists := fileSet.Run(ctx, analyzeResponse, newResponseHists).(*responseHists)
+	set := saveSet(label)
+	for shower, name := range showerTypeNames {
+		savedHists.Add(set+"/response/"+name, hists.response[shower], "E_cluster / E_true", "count")
+		for i, h := range hists.vsEta[shower] {
+			savedHists.Add(fmt.Sprintf("%v/responseVsEta/%v/%d", set, name, i), h, "E_cluster / E_true", "count")
+		}
+	}
+
+	for shower, h := range hists.response {
+		hResponse := hplot.NewH1D(h)
+		style.Apply(hResponse)
+		if !*inputsAreDirs {
+			hResponse.Infos.Style = hplot.HInfoSummary
+		}
+		page.Plot(shower, 0).Add(hResponse)
+		page.Plot(shower, 0).Legend.Add(label, hResponse)
+
+		vsEta, _ := ana.CoreCurves(hists.vsEta[shower][:], minEta, maxEta, minFitEntries, fitCoreNSigma)
+		if vsEta.Len() > 0 {
+			page.Plot(shower, 1).Add(style.NewErrorPlot(vsEta))
+		}
+	}
 }
-func dotProduct(vector1 [3]float64, vector2 [3]float64) float64 {
-	return vector1[0]*vector2[0] + vector1[1]*vector2[1] + vector1[2]*vector2[2]
+
+// analyzeResponse matches clusters to final-state MCParticles and sends the
+// response of each matched particle.  In angle matching, each photon and
+// neutral hadron is matched to the cluster closest to its direction, since
+// the clusters of charged particles are displaced by the field.  In hits
+// matching, each cluster is assigned to the particle that deposited most of
+// its energy, and the energies of the clusters assigned to each particle are
+// summed.
+func analyzeResponse(event *lcio.Event, out func(result interface{})) error {
+	truthColl, err := ana.MCParticles(event, *mcName)
+	if err != nil {
+		return err
+	}
+	clusterColl, err := ana.Clusters(event, *clusterName)
+	if err != nil {
+		return err
+	}
+
+	clusterEnergies := make(map[*lcio.McParticle]float64)
+	if *matchMode == "hits" {
+		hitTruth, nRelColls := ana.NewHitTruth(event, strings.Split(*relationNames, ","))
+		if nRelColls == 0 {
+			return fmt.Errorf("no calorimeter hit relation collections (%v); use -match angle", *relationNames)
+		}
+
+		for i := range clusterColl.Clusters {
+			if particle, _ := hitTruth.MatchCluster(&clusterColl.Clusters[i]); particle != nil {
+				clusterEnergies[particle] += float64(clusterColl.Clusters[i].Energy)
+			}
+		}
+	} else {
+		clusterDirs := make([]ana.Vec3, len(clusterColl.Clusters))
+		for i, cluster := range clusterColl.Clusters {
+			clusterDirs[i] = frame.Direction(ana.Vec3From32(cluster.Pos))
+		}
+
+		// each cluster is matched to at most one particle, the most energetic
+		// ones choosing first, so that the photons of a pi0 sharing a cluster
+		// do not both count its energy
+		var neutrals []*lcio.McParticle
+		for i, truth := range truthColl.Particles {
+			if truth.GenStatus == 1 && truth.Charge == 0 {
+				neutrals = append(neutrals, &truthColl.Particles[i])
+			}
+		}
+		sort.Slice(neutrals, func(i, j int) bool { return neutrals[i].Energy() > neutrals[j].Energy() })
+
+		used := make([]bool, len(clusterDirs))
+		for _, truth := range neutrals {
+			p := frame.Momentum(ana.Vec3(truth.P), truth.Energy())
+			match := -1
+			minAngle := maxMatchAngle
+			for j, dir := range clusterDirs {
+				if used[j] {
+					continue
+				}
+				if angle := p.Angle(dir); angle < minAngle {
+					minAngle = angle
+					match = j
+				}
+			}
+			if match >= 0 {
+				used[match] = true
+				clusterEnergies[truth] = float64(clusterColl.Clusters[match].Energy)
+			}
+		}
+	}
+
+	for particle, clusterEnergy := range clusterEnergies {
+		if particle.GenStatus != 1 || particle.Energy() < minTruthEnergy {
+			continue
+		}
+
+		shower := hadronicShower
+		switch particle.PDG {
+		case 22, 11, -11:
+			shower = emShower
+		case 12, -12, 13, -13, 14, -14, 16, -16:
+			continue
+		}
+
+		out(ResponseResult{
+			Shower:   shower,
+			Eta:      frame.Momentum(ana.Vec3(particle.P), particle.Energy()).Eta(),
+			Response: clusterEnergy / particle.Energy(),
+		})
+	}
+	return nil
 }
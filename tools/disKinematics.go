@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path"
+
+	"go-hep.org/x/hep/hbook"
+	"go-hep.org/x/hep/hplot"
+	"go-hep.org/x/hep/lcio"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+
+	"github.com/decibelcooper/SiEIC/ana"
+)
+
+var (
+	crossingAngleSource = flag.String("xangle", ana.DefaultCrossingAngleSource, "compact detector description or SLIC macro defining the crossing angle")
+	electronBeamEnergy  = flag.Float64("ebeam", 0, "electron beam energy in GeV (default from the beam MCParticles of each event)")
+	frameName           = flag.String("frame", "headon", "frame in which to reconstruct kinematics: headon, or lab to keep the crossing-angle boost")
+	hadronBeamEnergy    = flag.Float64("hbeam", 0, "hadron beam energy in GeV (default from the beam MCParticles of each event)")
+	inputsAreDirs       = flag.Bool("d", false, "inputs are directories")
+	maxFailFrac         = flag.Float64("maxfail", 0, "fraction of input files that may fail to be read before exiting with status 1")
+	maxFiles            = flag.Int("m", math.MaxInt32, "maximum number of files to process")
+	mcName              = flag.String("mc", ana.DefaultMCParticles, "name of the MCParticle collection")
+	nThreads            = flag.Int("t", 2, "number of concurrent files to process")
+	outputPath          = flag.String("o", "out.pdf", "path of output file")
+	pfoName             = flag.String("pfos", ana.DefaultPFOs, "name of the ReconstructedParticle collection")
+)
+
+var (
+	ctx   context.Context
+	frame ana.Frame
+)
+
+const (
+	electronPDG = 11
+
+	minX          = 1e-4
+	maxX          = 1
+	nXBins        = 16
+	minQ2         = 1
+	maxQ2         = 1e4
+	nQ2Bins       = 16
+	maxResidual   = 1
+	nResidualBins = 100
+	resPageWidth  = 10 * vg.Inch
+	resPageHeight = 7 * vg.Inch
+)
+
+type disVar int
+
+const (
+	xVar disVar = iota
+	q2Var
+	yVar
+	wVar
+	nDISVars
+)
+
+var disVarNames = [nDISVars]string{"x", "Q^2", "y", "W"}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: disKinematics [options] <lcio-input-file>
+options:
+`,
+		)
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+	defer ana.ExitIfFailed()
+	ctx = ana.InterruptContext()
+
+	var err error
+	frame, err = ana.NewFrame(*frameName, *crossingAngleSource)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	resPages := newResolutionPages()
+	var mapPages []hplot.Drawer
+
+	if *inputsAreDirs {
+		for i, dir := range flag.Args() {
+			inputFiles, err := ana.DirFiles(dir)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			mapPages = append(mapPages, drawFileSet(inputFiles, resPages, ana.SetStyle(i), path.Base(dir))...)
+		}
+	} else {
+		mapPages = drawFileSet(flag.Args(), resPages, ana.LineStyle{Color: ana.Blue}, "PandoraPFO")
+	}
+
+	pages := make([]hplot.Drawer, 0, len(resPages)+len(mapPages))
+	for _, page := range resPages {
+		pages = append(pages, page)
+	}
+	pages = append(pages, mapPages...)
+
+	if err := ana.SavePages(pages, resPageWidth, resPageHeight, *outputPath); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// DISResult holds the true kinematics of an event and those reconstructed by
+// each method, indexed by ana.DISMethod.  Valid reports which methods yielded
+// physical values.
+type DISResult struct {
+	True  ana.DIS
+	Reco  [ana.NDISMethods]ana.DIS
+	Valid [ana.NDISMethods]bool
+}
+
+// disHists accumulate the DIS results of one worker, indexed by
+// ana.DISMethod.
+type disHists struct {
+	residuals  [ana.NDISMethods][nDISVars]*hbook.H1D
+	migrations [ana.NDISMethods]*migrationHists
+}
+
+func newDISHists() ana.Collector {
+	h := &disHists{}
+	for method := range h.residuals {
+		for i := range h.residuals[method] {
+			h.residuals[method][i] = hbook.NewH1D(nResidualBins, -maxResidual, maxResidual)
+		}
+		h.migrations[method] = newMigrationHists()
+	}
+	return h
+}
+
+func (h *disHists) Collect(result interface{}) {
+	switch result := result.(type) {
+	case DISResult:
+		trueVars := [nDISVars]float64{result.True.X, result.True.Q2, result.True.Y, result.True.W}
+		for method, reco := range result.Reco {
+			h.migrations[method].fill(result.True, reco, result.Valid[method])
+			if !result.Valid[method] {
+				continue
+			}
+
+			recoVars := [nDISVars]float64{reco.X, reco.Q2, reco.Y, reco.W}
+			for i := range recoVars {
+				h.residuals[method][i].Fill((recoVars[i]-trueVars[i])/trueVars[i], 1)
+			}
+		}
+	}
+}
+
+func (h *disHists) Merge(other ana.Collector) {
+	o := other.(*disHists)
+	for method := range h.residuals {
+		ana.MergeH1Ds(h.residuals[method][:], o.residuals[method][:])
+		h.migrations[method].merge(o.migrations[method])
+	}
+}
+
+// migrationHists count events in bins of x and Q^2, for the purity and
+// stability of one reconstruction method.  The purity is the fraction of the
+// events reconstructed in a bin that were generated in it, and the stability
+// the fraction of all events generated in a bin, including those the method
+// failed to reconstruct, that were reconstructed in it.
+type migrationHists struct {
+	true *hbook.H2D
+	reco *hbook.H2D
+	// same counts events reconstructed in the bin they were generated in
+	same *hbook.H2D
+}
+
+func newMigrationHists() *migrationHists {
+	xEdges := ana.LogEdges(minX, maxX, nXBins)
+	q2Edges := ana.LogEdges(minQ2, maxQ2, nQ2Bins)
+	return &migrationHists{
+		true: hbook.NewH2DFromEdges(xEdges, q2Edges),
+		reco: hbook.NewH2DFromEdges(xEdges, q2Edges),
+		same: hbook.NewH2DFromEdges(xEdges, q2Edges),
+	}
+}
+
+// fill counts an event with true kinematics trueDIS, and reconstructed
+// kinematics recoDIS if recoValid.
+func (m *migrationHists) fill(trueDIS, recoDIS ana.DIS, recoValid bool) {
+	m.true.Fill(trueDIS.X, trueDIS.Q2, 1)
+	if !recoValid {
+		return
+	}
+	m.reco.Fill(recoDIS.X, recoDIS.Q2, 1)
+
+	trueXBin, trueQ2Bin, ok := migrationBin(trueDIS)
+	if !ok {
+		return
+	}
+	recoXBin, recoQ2Bin, ok := migrationBin(recoDIS)
+	if ok && recoXBin == trueXBin && recoQ2Bin == trueQ2Bin {
+		m.same.Fill(trueDIS.X, trueDIS.Q2, 1)
+	}
+}
+
+func (m *migrationHists) merge(other *migrationHists) {
+	ana.MergeH2D(m.true, other.true)
+	ana.MergeH2D(m.reco, other.reco)
+	ana.MergeH2D(m.same, other.same)
+}
+
+// migrationBin returns the log-spaced x and Q^2 bin indices of dis, and
+// whether it falls inside the migration histograms.
+func migrationBin(dis ana.DIS) (int, int, bool) {
+	xBin := int(math.Floor(nXBins * math.Log(dis.X/minX) / math.Log(maxX/minX)))
+	q2Bin := int(math.Floor(nQ2Bins * math.Log(dis.Q2/minQ2) / math.Log(maxQ2/minQ2)))
+	ok := xBin >= 0 && xBin < nXBins && q2Bin >= 0 && q2Bin < nQ2Bins
+	return xBin, q2Bin, ok
+}
+
+// drawFileSet analyzes inputFiles, adds the resolutions of each method to
+// resPages, and returns the purity and stability maps of each method.
+func drawFileSet(inputFiles []string, resPages []*hplot.TiledPlot, style ana.LineStyle, label string) []hplot.Drawer {
+	if len(inputFiles) == 0 {
+		return nil
+	}
+
+	fileSet := ana.FileSet{Files: inputFiles, NThreads: *nThreads, MaxFiles: *maxFiles, MaxFailFrac: *maxFailFrac}
+	hists := fileSet.Run(ctx, analyzeEvent, newDISHists).(*disHists)
+
+	for method, page := range resPages {
+		for i, h := range hists.residuals[method] {
+			tile := page.Plot(i%2, i/2)
+
+			hResidual := hplot.NewH1D(h)
+			style.Apply(hResidual)
+			if !*inputsAreDirs {
+				hResidual.Infos.Style = hplot.HInfoSummary
+			}
+			tile.Add(hResidual)
+			if *inputsAreDirs {
+				tile.Legend.Add(label, hResidual)
+			}
+		}
+	}
+
+	var mapPages []hplot.Drawer
+	for method, m := range hists.migrations {
+		title := ana.DISMethod(method).String() + " Method"
+		if *inputsAreDirs {
+			title += ": " + label
+		}
+
+		page := hplot.NewTiledPlot(draw.Tiles{
+			Rows: 1,
+			Cols: 2,
+			PadX: 5 * vg.Millimeter,
+		})
+		*page.Plot(0, 0) = *newMigrationPlot(title+" Purity", ana.Efficiency2D(m.same, m.reco), m.reco)
+		*page.Plot(1, 0) = *newMigrationPlot(title+" Stability", ana.Efficiency2D(m.same, m.true), m.true)
+		mapPages = append(mapPages, page)
+	}
+	return mapPages
+}
+
+// newResolutionPages returns one page per reconstruction method, each with
+// tiles for the relative residual of each of the invariants.
+func newResolutionPages() []*hplot.TiledPlot {
+	var pages []*hplot.TiledPlot
+	for method := ana.DISMethod(0); method < ana.NDISMethods; method++ {
+		page := hplot.NewTiledPlot(draw.Tiles{
+			Rows: 2,
+			Cols: 2,
+			PadX: 5 * vg.Millimeter,
+			PadY: 5 * vg.Millimeter,
+		})
+
+		for i, name := range disVarNames {
+			title := fmt.Sprintf("%v Method %v Resolution", method, name)
+			xLabel := fmt.Sprintf("(reco - true) / true %v", name)
+			*page.Plot(i%2, i/2) = *ana.NewPlot(title, xLabel, "count")
+		}
+
+		pages = append(pages, page)
+	}
+	return pages
+}
+
+// newMigrationPlot returns a color map of the fraction eff in bins of x and
+// Q^2, blank where total, the denominator of eff, is empty.
+func newMigrationPlot(title string, eff, total *hbook.H2D) *hplot.Plot {
+	p := ana.NewPlot(title, "x", "Q^2 {GeV^2}")
+	p.X.Scale = plot.LogScale{}
+	p.X.Tick.Marker = plot.LogTicks{Prec: -1}
+	p.Y.Scale = plot.LogScale{}
+	p.Y.Tick.Marker = plot.LogTicks{Prec: -1}
+	p.Add(ana.NewEfficiencyMap(eff, total))
+	return p
+}
+
+func analyzeEvent(event *lcio.Event, out func(result interface{})) error {
+	truthColl, err := ana.MCParticles(event, *mcName)
+	if err != nil {
+		return err
+	}
+	pfoColl, err := ana.PFOs(event, *pfoName)
+	if err != nil {
+		return err
+	}
+
+	beamElectron, beamHadron, err := ana.BeamParticles(truthColl.Particles)
+	if err != nil {
+		return err
+	}
+	trueDIS, err := ana.TrueDIS(beamElectron, beamHadron)
+	if err != nil {
+		return err
+	}
+
+	// the beam energies are those of the frame the methods are applied in
+	beams := ana.Beams{Electron: *electronBeamEnergy, Hadron: *hadronBeamEnergy}
+	if beams.Electron <= 0 {
+		_, beams.Electron = frame.FourMomentum(ana.Vec3(beamElectron.P), beamElectron.Energy())
+	}
+	if beams.Hadron <= 0 {
+		_, beams.Hadron = frame.FourMomentum(ana.Vec3(beamHadron.P), beamHadron.Energy())
+	}
+
+	electronIndex := -1
+	for i, pfo := range pfoColl.Parts {
+		if pfo.Type != electronPDG {
+			continue
+		}
+		if electronIndex < 0 || pfo.Energy > pfoColl.Parts[electronIndex].Energy {
+			electronIndex = i
+		}
+	}
+	if electronIndex < 0 {
+		// counted against the stability of every method
+		out(DISResult{True: trueDIS})
+		return nil
+	}
+
+	var electronP ana.Vec3
+	var electronEnergy float64
+	var hfs ana.HadronicFinalState
+	for i, pfo := range pfoColl.Parts {
+		p, energy := frame.FourMomentum(ana.Vec3From32(pfo.P), float64(pfo.Energy))
+		if i == electronIndex {
+			electronP, electronEnergy = p, energy
+		} else {
+			hfs.Add(p, energy)
+		}
+	}
+
+	result := DISResult{True: trueDIS}
+	for method := ana.DISMethod(0); method < ana.NDISMethods; method++ {
+		result.Reco[method], result.Valid[method] = method.Reconstruct(beams, electronP, electronEnergy, hfs)
+	}
+	out(result)
+	return nil
+}
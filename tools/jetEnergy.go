@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path"
+	"sort"
+
+	"go-hep.org/x/hep/fastjet"
+	"go-hep.org/x/hep/hbook"
+	"go-hep.org/x/hep/hplot"
+	"go-hep.org/x/hep/lcio"
+
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+
+	"github.com/decibelcooper/SiEIC/ana"
+)
+
+var (
+	algorithmName       = flag.String("alg", "antikt", "jet algorithm: antikt, kt or cambridge")
+	crossingAngleSource = flag.String("xangle", ana.DefaultCrossingAngleSource, "compact detector description or SLIC macro defining the crossing angle")
+	frameName           = flag.String("frame", "lab", "frame in which to cluster jets: lab, or headon to remove the crossing-angle boost")
+	inputsAreDirs       = flag.Bool("d", false, "inputs are directories")
+	intervalName        = flag.String("ci", "clopper-pearson", "binomial interval for the matching efficiency: clopper-pearson or wilson")
+	jetR                = flag.Float64("R", 1, "jet radius parameter")
+	matchR              = flag.Float64("matchr", 0.5, "maximum rapidity-phi distance between matched reco and truth jets")
+	maxFailFrac         = flag.Float64("maxfail", 0, "fraction of input files that may fail to be read before exiting with status 1")
+	maxFiles            = flag.Int("m", math.MaxInt32, "maximum number of files to process")
+	mcName              = flag.String("mc", ana.DefaultMCParticles, "name of the MCParticle collection")
+	minJetP_T           = flag.Float64("ptmin", 4, "minimum p_T in GeV of truth jets")
+	nThreads            = flag.Int("t", 2, "number of concurrent files to process")
+	outputPath          = flag.String("o", "out.pdf", "path of output file")
+	pfoName             = flag.String("pfos", ana.DefaultPFOs, "name of the ReconstructedParticle collection")
+)
+
+var (
+	ctx   context.Context
+	frame ana.Frame
+
+	// jetDef is the jet definition selected by the -alg and -R flags.
+	jetDef fastjet.JetDefinition
+)
+
+const (
+	// recoMinP_TFraction is the fraction of -ptmin above which reco jets are
+	// kept for matching, low enough that the response near threshold is not
+	// biased by the reco jet cut
+	recoMinP_TFraction = 0.5
+
+	electronPDG = 11
+
+	minJetEta     = -4
+	maxJetEta     = 4
+	nJetEtaBins   = 8
+	maxJetP_T     = 40
+	nJetP_TBins   = 9
+	maxResponse   = 2
+	nResponseBins = 100
+	minFitEntries = 50
+	fitCoreNSigma = 2
+	pageWidth     = 10 * vg.Inch
+	pageHeight    = 7 * vg.Inch
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: jetEnergy [options] <lcio-input-file>
+options:
+`,
+		)
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+	defer ana.ExitIfFailed()
+	ctx = ana.InterruptContext()
+
+	var err error
+	frame, err = ana.NewFrame(*frameName, *crossingAngleSource)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var algorithm fastjet.JetAlgorithm
+	switch *algorithmName {
+	case "antikt":
+		algorithm = fastjet.AntiKtAlgorithm
+	case "kt":
+		algorithm = fastjet.KtAlgorithm
+	case "cambridge":
+		algorithm = fastjet.CambridgeAlgorithm
+	default:
+		log.Fatalf("unknown jet algorithm %q", *algorithmName)
+	}
+	jetDef = fastjet.NewJetDefinition(algorithm, *jetR, fastjet.EScheme, fastjet.BestStrategy)
+
+	interval, err := ana.ParseInterval(*intervalName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	page := newJetPage()
+	if *inputsAreDirs {
+		for i, dir := range flag.Args() {
+			inputFiles, err := ana.DirFiles(dir)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			drawFileSet(inputFiles, page, ana.SetStyle(i), path.Base(dir), interval)
+		}
+	} else {
+		drawFileSet(flag.Args(), page, ana.LineStyle{Color: ana.Blue}, "PandoraPFO", interval)
+	}
+
+	if err := ana.SavePages([]hplot.Drawer{page}, pageWidth, pageHeight, *outputPath); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// JetResult describes a truth jet, and the ratio of the energy of the reco jet
+// matched to it to its own if Matched.
+type JetResult struct {
+	Eta      float64
+	P_T      float64
+	Matched  bool
+	Response float64
+}
+
+// newJetPage returns a page with tiles for the jet response, the jet energy
+// scale and resolution versus truth jet p_T and eta, and the efficiency with
+// which truth jets are matched.
+func newJetPage() *hplot.TiledPlot {
+	page := hplot.NewTiledPlot(draw.Tiles{
+		Rows: 2,
+		Cols: 3,
+		PadX: 5 * vg.Millimeter,
+		PadY: 5 * vg.Millimeter,
+	})
+
+	title := fmt.Sprintf("%v R=%g", *algorithmName, *jetR)
+	labels := [][3]string{
+		{title + " Jet Response", "reco E / true E", "count"},
+		{"Jet Energy Scale vs. p_T", "true jet p_T {GeV}", "mean response"},
+		{"Jet Energy Scale vs. eta", "true jet eta", "mean response"},
+		{"Jet Matching Efficiency vs. p_T", "true jet p_T {GeV}", "efficiency"},
+		{"Jet Energy Resolution vs. p_T", "true jet p_T {GeV}", "sigma / mean response"},
+		{"Jet Energy Resolution vs. eta", "true jet eta", "sigma / mean response"},
+	}
+	for i, label := range labels {
+		*page.Plot(i%3, i/3) = *ana.NewPlot(label[0], label[1], label[2])
+	}
+	return page
+}
+
+// jetHists accumulate the jet results of one worker.
+type jetHists struct {
+	response *hbook.H1D
+	trueP_T  *hbook.H1D
+	matchP_T *hbook.H1D
+	vsP_T    [nJetP_TBins]*hbook.H1D
+	vsEta    [nJetEtaBins]*hbook.H1D
+}
+
+func newJetHists() ana.Collector {
+	h := &jetHists{
+		response: hbook.NewH1D(nResponseBins, 0, maxResponse),
+		trueP_T:  hbook.NewH1D(nJetP_TBins, *minJetP_T, maxJetP_T),
+		matchP_T: hbook.NewH1D(nJetP_TBins, *minJetP_T, maxJetP_T),
+	}
+	for i := range h.vsP_T {
+		h.vsP_T[i] = hbook.NewH1D(nResponseBins, 0, maxResponse)
+	}
+	for i := range h.vsEta {
+		h.vsEta[i] = hbook.NewH1D(nResponseBins, 0, maxResponse)
+	}
+	return h
+}
+
+func (h *jetHists) Collect(result interface{}) {
+	switch result := result.(type) {
+	case JetResult:
+		h.trueP_T.Fill(result.P_T, 1)
+		if !result.Matched {
+			return
+		}
+		h.matchP_T.Fill(result.P_T, 1)
+		h.response.Fill(result.Response, 1)
+
+		p_TBin := int(math.Floor(nJetP_TBins * (result.P_T - *minJetP_T) / (maxJetP_T - *minJetP_T)))
+		if p_TBin >= 0 && p_TBin < nJetP_TBins {
+			h.vsP_T[p_TBin].Fill(result.Response, 1)
+		}
+		etaBin := int(math.Floor(nJetEtaBins * (result.Eta - minJetEta) / (maxJetEta - minJetEta)))
+		if etaBin >= 0 && etaBin < nJetEtaBins {
+			h.vsEta[etaBin].Fill(result.Response, 1)
+		}
+	}
+}
+
+func (h *jetHists) Merge(other ana.Collector) {
+	o := other.(*jetHists)
+	ana.MergeH1D(h.response, o.response)
+	ana.MergeH1D(h.trueP_T, o.trueP_T)
+	ana.MergeH1D(h.matchP_T, o.matchP_T)
+	ana.MergeH1Ds(h.vsP_T[:], o.vsP_T[:])
+	ana.MergeH1Ds(h.vsEta[:], o.vsEta[:])
+}
+
+// drawFileSet analyzes inputFiles and adds the resulting distributions to
+// page, with the scale and resolution taken from Gaussian fits to the
+// response cores.
+func drawFileSet(inputFiles []string, page *hplot.TiledPlot, style ana.LineStyle, label string, interval ana.Interval) {
+	fileSet := ana.FileSet{Files: inputFiles, NThreads: *nThreads, MaxFiles: *maxFiles, MaxFailFrac: *maxFailFrac}
+	h := fileSet.Run(ctx, analyzeEvent, newJetHists).(*jetHists)
+
+	hResponse := hplot.NewH1D(h.response)
+	style.Apply(hResponse)
+	if !*inputsAreDirs {
+		hResponse.Infos.Style = hplot.HInfoSummary
+	}
+	page.Plot(0, 0).Add(hResponse)
+	page.Plot(0, 0).Legend.Add(label, hResponse)
+
+	hEff := style.NewErrorPlot(ana.Efficiency(h.matchP_T, h.trueP_T, interval))
+	page.Plot(0, 1).Add(hEff)
+
+	scaleVsP_T, resVsP_T := scaleAndResolution(h.vsP_T[:], *minJetP_T, maxJetP_T)
+	scaleVsEta, resVsEta := scaleAndResolution(h.vsEta[:], minJetEta, maxJetEta)
+	curves := []struct {
+		row, col int
+		curve    *hbook.S2D
+	}{
+		{0, 1, scaleVsP_T},
+		{0, 2, scaleVsEta},
+		{1, 1, resVsP_T},
+		{1, 2, resVsEta},
+	}
+	for _, c := range curves {
+		if c.curve.Len() > 0 {
+			page.Plot(c.col, c.row).Add(style.NewErrorPlot(c.curve))
+		}
+	}
+}
+
+// scaleAndResolution returns the fitted core mean of each of the response
+// histograms in hists, which evenly divide the range from min to max, and the
+// fitted width relative to that mean.
+func scaleAndResolution(hists []*hbook.H1D, min, max float64) (*hbook.S2D, *hbook.S2D) {
+	means, sigmas := ana.CoreCurves(hists, min, max, minFitEntries, fitCoreNSigma)
+
+	resolutions := hbook.NewS2D()
+	for i, mean := range means.Points() {
+		sigma := sigmas.Point(i)
+		if mean.Y <= 0 {
+			continue
+		}
+
+		res := sigma.Y / mean.Y
+		meanRelErr := mean.ErrY.Max / mean.Y
+		sigmaRelErr := sigma.ErrY.Max / sigma.Y
+		resErr := res * math.Hypot(meanRelErr, sigmaRelErr)
+		resolutions.Fill(hbook.Point2D{
+			X:    mean.X,
+			Y:    res,
+			ErrX: mean.ErrX,
+			ErrY: hbook.Range{Min: resErr, Max: resErr},
+		})
+	}
+	return means, resolutions
+}
+
+func analyzeEvent(event *lcio.Event, out func(result interface{})) error {
+	truthColl, err := ana.MCParticles(event, *mcName)
+	if err != nil {
+		return err
+	}
+	pfoColl, err := ana.PFOs(event, *pfoName)
+	if err != nil {
+		return err
+	}
+
+	// the scattered electron is not part of the hadronic final state, so it is
+	// left out of the jet inputs on both sides: in truth the most energetic
+	// final-state electron, in reco the most energetic PFO identified as one
+	truthElectron := -1
+	for i, truth := range truthColl.Particles {
+		if truth.GenStatus != 1 || truth.PDG != electronPDG {
+			continue
+		}
+		if truthElectron < 0 || truth.Energy() > truthColl.Particles[truthElectron].Energy() {
+			truthElectron = i
+		}
+	}
+	pfoElectron := -1
+	for i, pfo := range pfoColl.Parts {
+		if pfo.Type != electronPDG {
+			continue
+		}
+		if pfoElectron < 0 || pfo.Energy > pfoColl.Parts[pfoElectron].Energy {
+			pfoElectron = i
+		}
+	}
+
+	var truthParticles []fastjet.Jet
+	for i, truth := range truthColl.Particles {
+		if truth.GenStatus != 1 || i == truthElectron {
+			continue
+		}
+		switch truth.PDG {
+		case 12, -12, 14, -14, 16, -16:
+			continue
+		}
+
+		truthParticles = append(truthParticles, newJetInput(ana.Vec3(truth.P), truth.Energy()))
+	}
+
+	var pfoParticles []fastjet.Jet
+	for i, pfo := range pfoColl.Parts {
+		if i == pfoElectron {
+			continue
+		}
+		pfoParticles = append(pfoParticles, newJetInput(ana.Vec3From32(pfo.P), float64(pfo.Energy)))
+	}
+
+	truthJets, err := clusterJets(truthParticles, *minJetP_T)
+	if err != nil {
+		return err
+	}
+	recoJets, err := clusterJets(pfoParticles, recoMinP_TFraction**minJetP_T)
+	if err != nil {
+		return err
+	}
+
+	used := make([]bool, len(recoJets))
+	for i := range truthJets {
+		truthJet := &truthJets[i]
+		result := JetResult{Eta: truthJet.Eta(), P_T: truthJet.Pt()}
+
+		match := -1
+		minDist := *matchR
+		for j := range recoJets {
+			if used[j] {
+				continue
+			}
+
+			dist := math.Sqrt(fastjet.Distance(truthJet, &recoJets[j]))
+			if dist < minDist {
+				minDist = dist
+				match = j
+			}
+		}
+
+		if match >= 0 {
+			used[match] = true
+			result.Matched = true
+			result.Response = recoJets[match].E() / truthJet.E()
+		}
+		out(result)
+	}
+	return nil
+}
+
+// newJetInput returns a clustering input for the particle with lab-frame
+// momentum p and the given energy, in the analysis frame.
+func newJetInput(p ana.Vec3, energy float64) fastjet.Jet {
+	p, energy = frame.FourMomentum(p, energy)
+	return fastjet.NewJet(p[0], p[1], p[2], energy)
+}
+
+// clusterJets returns the inclusive jets of particles above minP_T, ordered by
+// decreasing p_T.
+func clusterJets(particles []fastjet.Jet, minP_T float64) ([]fastjet.Jet, error) {
+	if len(particles) == 0 {
+		return nil, nil
+	}
+
+	cs, err := fastjet.NewClusterSequence(particles, jetDef)
+	if err != nil {
+		return nil, fmt.Errorf("jet clustering: %v", err)
+	}
+
+	jets, err := cs.InclusiveJets(minP_T)
+	if err != nil {
+		return nil, fmt.Errorf("jet clustering: %v", err)
+	}
+
+	sort.Slice(jets, func(i, j int) bool { return jets[i].Pt() > jets[j].Pt() })
+	return jets, nil
+}
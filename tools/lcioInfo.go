@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"go-hep.org/x/hep/lcio"
+
+	"github.com/decibelcooper/SiEIC/ana"
+)
+
+var (
+	inputsAreDirs   = flag.Bool("d", false, "inputs are directories")
+	jsonOutput      = flag.Bool("json", false, "write JSON instead of tables")
+	maxFailFrac     = flag.Float64("maxfail", 0, "fraction of input files that may fail to be read before exiting with status 1")
+	maxFiles        = flag.Int("m", math.MaxInt32, "maximum number of files to process")
+	nThreads        = flag.Int("t", 2, "number of concurrent files to process")
+	requiredNames   = flag.String("require", "", "comma-separated collections that must be in every event; exit with status 1 if any are missing")
+	showCollParams  = flag.Bool("params", false, "list collection parameters in tables (always included in JSON)")
+	showPerFileInfo = flag.Bool("files", true, "list the runs and collections of each file in tables, in addition to the summary over all files")
+)
+
+var ctx context.Context
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: lcioInfo [options] <lcio-input-file>...
+Lists the runs, events and collections of LCIO files, with the type of each
+collection and the minimum, mean and maximum number of elements it holds per
+event.  The elements themselves are not summarized.
+options:
+`,
+		)
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+	defer ana.ExitIfFailed()
+	ctx = ana.InterruptContext()
+
+	inputFiles := flag.Args()
+	if *inputsAreDirs {
+		inputFiles = nil
+		for _, dir := range flag.Args() {
+			dirFiles, err := ana.DirFiles(dir)
+			if err != nil {
+				log.Fatal(err)
+			}
+			inputFiles = append(inputFiles, dirFiles...)
+		}
+	}
+
+	var required []string
+	if *requiredNames != "" {
+		required = strings.Split(*requiredNames, ",")
+	}
+
+	info := Info{Files: inspectFiles(inputFiles)}
+	info.Summary = summarize(info.Files)
+	info.Missing = findMissing(info.Files, required)
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(info); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		printInfo(os.Stdout, info)
+	}
+
+	for _, m := range info.Missing {
+		log.Printf("%v: %v missing from %v of %v events", m.File, m.Collection, m.NEvents, m.NFileEvents)
+	}
+	if len(info.Missing) > 0 {
+		// failed files and interrupts take precedence, as for other commands
+		ana.ExitIfFailed()
+		os.Exit(1)
+	}
+}
+
+// Info is the output of the command.
+type Info struct {
+	Files   []FileInfo       `json:"files"`
+	Summary []CollectionInfo `json:"summary"`
+	Missing []MissingInfo    `json:"missing,omitempty"`
+}
+
+// FileInfo describes the contents of one LCIO file.  Err holds the error that
+// stopped it from being read to the end, if any.
+type FileInfo struct {
+	Path        string           `json:"path"`
+	NEvents     int              `json:"nEvents"`
+	Runs        []RunInfo        `json:"runs"`
+	Collections []CollectionInfo `json:"collections"`
+	Err         string           `json:"error,omitempty"`
+}
+
+// RunInfo describes a run header and the events following it.
+type RunInfo struct {
+	Number      int32                  `json:"number"`
+	Detector    string                 `json:"detector"`
+	Description string                 `json:"description,omitempty"`
+	Params      map[string]interface{} `json:"params,omitempty"`
+	NEvents     int                    `json:"nEvents"`
+}
+
+// CollectionInfo summarizes the lengths of a collection over the events in
+// which it is present.  Its parameters are those of its first occurrence.
+type CollectionInfo struct {
+	Name    string                 `json:"name"`
+	Type    string                 `json:"type"`
+	NFiles  int                    `json:"nFiles"`
+	NEvents int                    `json:"nEvents"`
+	Min     int                    `json:"min"`
+	Max     int                    `json:"max"`
+	Total   int                    `json:"total"`
+	Params  map[string]interface{} `json:"params,omitempty"`
+}
+
+// Mean returns the mean length of the collection.
+func (c CollectionInfo) Mean() float64 {
+	if c.NEvents == 0 {
+		return 0
+	}
+	return float64(c.Total) / float64(c.NEvents)
+}
+
+func (c *CollectionInfo) add(length int) {
+	if c.NEvents == 0 || length < c.Min {
+		c.Min = length
+	}
+	if length > c.Max {
+		c.Max = length
+	}
+	c.NEvents++
+	c.Total += length
+}
+
+// merge adds the lengths summarized by other to c.
+func (c *CollectionInfo) merge(other CollectionInfo) {
+	if other.NEvents == 0 {
+		return
+	}
+	if c.NEvents == 0 || other.Min < c.Min {
+		c.Min = other.Min
+	}
+	if other.Max > c.Max {
+		c.Max = other.Max
+	}
+	c.NFiles += other.NFiles
+	c.NEvents += other.NEvents
+	c.Total += other.Total
+	if c.Params == nil {
+		c.Params = other.Params
+	}
+}
+
+// MissingInfo reports a required collection absent from NEvents of the
+// NFileEvents events in File.
+type MissingInfo struct {
+	File        string `json:"file"`
+	Collection  string `json:"collection"`
+	NEvents     int    `json:"nEvents"`
+	NFileEvents int    `json:"nFileEvents"`
+}
+
+// EventResult describes the collections of an event of run Run.
+type EventResult struct {
+	Run         int32
+	Detector    string
+	Collections []CollectionResult
+}
+
+// CollectionResult describes a collection of an event.
+type CollectionResult struct {
+	Name   string
+	Type   string
+	Length int
+	Params lcio.Params
+}
+
+// fileCollector accumulates the description of a file, or of the part of it
+// read by one worker.  Runs and collections are keyed by number and name.
+type fileCollector struct {
+	nEvents     int
+	runs        map[int32]*RunInfo
+	collections map[string]*CollectionInfo
+}
+
+func newFileCollector() ana.Collector {
+	return &fileCollector{
+		runs:        make(map[int32]*RunInfo),
+		collections: make(map[string]*CollectionInfo),
+	}
+}
+
+func (c *fileCollector) Collect(result interface{}) {
+	event := result.(EventResult)
+	c.nEvents++
+
+	run := c.runs[event.Run]
+	if run == nil {
+		run = &RunInfo{Number: event.Run, Detector: event.Detector}
+		c.runs[event.Run] = run
+	}
+	run.NEvents++
+
+	for _, collResult := range event.Collections {
+		coll := c.collections[collResult.Name]
+		if coll == nil {
+			coll = &CollectionInfo{Name: collResult.Name, Type: collResult.Type, NFiles: 1}
+			c.collections[collResult.Name] = coll
+		}
+		coll.add(collResult.Length)
+		if coll.Params == nil {
+			coll.Params = paramsMap(collResult.Params)
+		}
+	}
+}
+
+func (c *fileCollector) Merge(other ana.Collector) {
+	o := other.(*fileCollector)
+	c.nEvents += o.nEvents
+	for number, oRun := range o.runs {
+		run := c.runs[number]
+		if run == nil {
+			run = &RunInfo{Number: number, Detector: oRun.Detector}
+			c.runs[number] = run
+		}
+		run.NEvents += oRun.NEvents
+	}
+	for name, oColl := range o.collections {
+		coll := c.collections[name]
+		if coll == nil {
+			coll = &CollectionInfo{Name: name, Type: oColl.Type}
+			c.collections[name] = coll
+		}
+		coll.merge(*oColl)
+		// the parts of a file split among workers count as one file
+		coll.NFiles = 1
+	}
+}
+
+// inspectFiles reads inputFiles as an ana.FileSet and returns their
+// descriptions in the same order.
+func inspectFiles(inputFiles []string) []FileInfo {
+	fileSet := ana.FileSet{Files: inputFiles, NThreads: *nThreads, MaxFiles: *maxFiles, MaxFailFrac: *maxFailFrac}
+	results := fileSet.RunFiles(ctx, inspectEvent, newFileCollector)
+
+	infos := make([]FileInfo, len(results))
+	for i, result := range results {
+		c := result.Collector.(*fileCollector)
+		info := FileInfo{Path: result.Path, NEvents: c.nEvents}
+		for _, run := range c.runs {
+			if header, ok := result.RunHeaders[run.Number]; ok {
+				run.Description = header.Descr
+				run.Params = paramsMap(header.Params)
+			}
+			info.Runs = append(info.Runs, *run)
+		}
+		for _, coll := range c.collections {
+			info.Collections = append(info.Collections, *coll)
+		}
+		if result.Err != nil {
+			info.Err = result.Err.Error()
+		}
+
+		sort.Slice(info.Runs, func(i, j int) bool { return info.Runs[i].Number < info.Runs[j].Number })
+		sort.Slice(info.Collections, func(i, j int) bool { return info.Collections[i].Name < info.Collections[j].Name })
+		infos[i] = info
+	}
+	return infos
+}
+
+func inspectEvent(event *lcio.Event, out func(result interface{})) error {
+	result := EventResult{Run: event.RunNumber, Detector: event.Detector}
+	for _, name := range event.Names() {
+		coll := event.Get(name)
+		length, params := describeCollection(coll)
+		result.Collections = append(result.Collections, CollectionResult{
+			Name:   name,
+			Type:   ana.CollectionType(coll),
+			Length: length,
+			Params: params,
+		})
+	}
+	out(result)
+	return nil
+}
+
+// describeCollection returns the number of elements of coll and its
+// parameters.  Every lcio collection type is a struct holding its Params and
+// a single slice of elements, so they are found by reflection rather than by
+// a switch over the types.
+func describeCollection(coll interface{}) (int, lcio.Params) {
+	v := reflect.Indirect(reflect.ValueOf(coll))
+	if v.Kind() != reflect.Struct {
+		return 0, lcio.Params{}
+	}
+
+	var params lcio.Params
+	if field := v.FieldByName("Params"); field.IsValid() {
+		params, _ = field.Interface().(lcio.Params)
+	}
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).Kind() == reflect.Slice {
+			return v.Field(i).Len(), params
+		}
+	}
+	return 0, params
+}
+
+// paramsMap flattens params into one map, or returns nil if it is empty.
+func paramsMap(params lcio.Params) map[string]interface{} {
+	m := make(map[string]interface{})
+	for key, value := range params.Ints {
+		m[key] = value
+	}
+	for key, value := range params.Floats {
+		m[key] = value
+	}
+	for key, value := range params.Strings {
+		m[key] = value
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+// summarize merges the collections of files by name.
+func summarize(files []FileInfo) []CollectionInfo {
+	var summary []CollectionInfo
+	index := make(map[string]int)
+	for _, file := range files {
+		for _, coll := range file.Collections {
+			i, ok := index[coll.Name]
+			if !ok {
+				i = len(summary)
+				index[coll.Name] = i
+				summary = append(summary, CollectionInfo{Name: coll.Name, Type: coll.Type})
+			}
+			summary[i].merge(coll)
+		}
+	}
+
+	sort.Slice(summary, func(i, j int) bool { return summary[i].Name < summary[j].Name })
+	return summary
+}
+
+// findMissing returns the required collections absent from any event of
+// files.
+func findMissing(files []FileInfo, required []string) []MissingInfo {
+	var missing []MissingInfo
+	for _, file := range files {
+		nEvents := make(map[string]int)
+		for _, coll := range file.Collections {
+			nEvents[coll.Name] = coll.NEvents
+		}
+
+		for _, name := range required {
+			if n := file.NEvents - nEvents[name]; n > 0 || file.NEvents == 0 {
+				missing = append(missing, MissingInfo{
+					File:        file.Path,
+					Collection:  name,
+					NEvents:     n,
+					NFileEvents: file.NEvents,
+				})
+			}
+		}
+	}
+	return missing
+}
+
+func printInfo(w io.Writer, info Info) {
+	nEvents := 0
+	for _, file := range info.Files {
+		nEvents += file.NEvents
+	}
+
+	if *showPerFileInfo {
+		for _, file := range info.Files {
+			fmt.Fprintf(w, "%v: %v events\n", file.Path, file.NEvents)
+			if file.Err != "" {
+				fmt.Fprintf(w, "  error: %v\n", file.Err)
+			}
+			for _, run := range file.Runs {
+				fmt.Fprintf(w, "  run %v: detector %q, %v events\n", run.Number, run.Detector, run.NEvents)
+				printParams(w, "    ", run.Params)
+			}
+			printCollections(w, file.Collections, file.NEvents)
+			fmt.Fprintln(w)
+		}
+	}
+
+	fmt.Fprintf(w, "%v files: %v events\n", len(info.Files), nEvents)
+	printCollections(w, info.Summary, nEvents)
+}
+
+// printCollections writes a table of colls, which are from nEvents events.
+func printCollections(w io.Writer, colls []CollectionInfo, nEvents int) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "  collection\ttype\tfiles\tevents\tmin\tmean\tmax\t")
+	for _, coll := range colls {
+		events := fmt.Sprint(coll.NEvents)
+		if coll.NEvents < nEvents {
+			events += " (missing " + fmt.Sprint(nEvents-coll.NEvents) + ")"
+		}
+		fmt.Fprintf(tw, "  %v\t%v\t%v\t%v\t%v\t%.1f\t%v\t\n", coll.Name, coll.Type, coll.NFiles, events, coll.Min, coll.Mean(), coll.Max)
+	}
+	tw.Flush()
+
+	if !*showCollParams {
+		return
+	}
+	for _, coll := range colls {
+		if len(coll.Params) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "  %v parameters:\n", coll.Name)
+		printParams(w, "    ", coll.Params)
+	}
+}
+
+func printParams(w io.Writer, indent string, params map[string]interface{}) {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(w, "%v%v = %v\n", indent, key, params[key])
+	}
+}
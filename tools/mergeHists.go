@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/decibelcooper/SiEIC/ana"
+)
+
+var (
+	inputsAreDirs = flag.Bool("d", false, "inputs are job output directories, whose histogram files are merged by their path within them")
+	outputPath    = flag.String("o", "merged.yoda", "path of output file, or of output directory with -d")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: mergeHists [options] <yoda-file>...
+       mergeHists [options] -d <job-output-dir>...
+Merges the histograms saved by separate jobs of trackEff, PFODist and
+clusterDist, and derives their efficiencies and ratios again from the merged
+histograms.
+options:
+`,
+		)
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if !*inputsAreDirs {
+		if err := mergeFiles(flag.Args(), *outputPath); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var relPaths []string
+	inputFiles := make(map[string][]string)
+	for _, dir := range flag.Args() {
+		err := filepath.Walk(dir, func(inputPath string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || filepath.Ext(inputPath) != ".yoda" {
+				return err
+			}
+
+			relPath, err := filepath.Rel(dir, inputPath)
+			if err != nil {
+				return err
+			}
+			if inputFiles[relPath] == nil {
+				relPaths = append(relPaths, relPath)
+			}
+			inputFiles[relPath] = append(inputFiles[relPath], inputPath)
+			return nil
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(relPaths) == 0 {
+		log.Fatal("no histogram files found")
+	}
+
+	for _, relPath := range relPaths {
+		mergedPath := filepath.Join(*outputPath, relPath)
+		if err := os.MkdirAll(filepath.Dir(mergedPath), 0755); err != nil {
+			log.Fatal(err)
+		}
+		if err := mergeFiles(inputFiles[relPath], mergedPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// mergeFiles merges the histograms of the files at inputPaths, which must
+// hold histograms of the same names and binnings, into the file at
+// mergedPath.
+func mergeFiles(inputPaths []string, mergedPath string) error {
+	merged, err := ana.ReadHists(inputPaths[0])
+	if err != nil {
+		return err
+	}
+	for _, inputPath := range inputPaths[1:] {
+		objects, err := ana.ReadHists(inputPath)
+		if err != nil {
+			return err
+		}
+		if err := ana.MergeHists(merged, objects); err != nil {
+			return fmt.Errorf("%v: %v", inputPath, err)
+		}
+	}
+
+	if err := ana.Rederive(merged); err != nil {
+		return fmt.Errorf("%v: %v", mergedPath, err)
+	}
+	if err := ana.WriteHists(mergedPath, merged); err != nil {
+		return err
+	}
+
+	log.Printf("%v: merged %v files", mergedPath, len(inputPaths))
+	return nil
+}
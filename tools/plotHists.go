@@ -0,0 +1,226 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"go-hep.org/x/hep/hbook"
+	"go-hep.org/x/hep/hplot"
+
+	"github.com/decibelcooper/SiEIC/ana"
+)
+
+var (
+	fileLabels  = flag.String("labels", "", "comma-separated legend labels of the input files, instead of their base names")
+	matchExpr   = flag.String("match", "", "regular expression selecting the histograms to draw by name")
+	normalize   = flag.Bool("n", false, "normalize 1D histograms to unit area")
+	outputPath  = flag.String("o", "out.pdf", "path of output file")
+	overlaySets = flag.Bool("sets", false, "overlay the input sets within each file, rather than drawing each on its own page")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: plotHists [options] <yoda-file>...
+Draws the histograms saved by trackEff, PFODist and clusterDist alongside
+their plots, one page per histogram name, overlaying those of the same name
+from each file.
+options:
+`,
+		)
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var match *regexp.Regexp
+	if *matchExpr != "" {
+		var err error
+		match, err = regexp.Compile(*matchExpr)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	labels := make([]string, flag.NArg())
+	for i, inputPath := range flag.Args() {
+		labels[i] = strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath))
+	}
+	if *fileLabels != "" {
+		given := strings.Split(*fileLabels, ",")
+		if len(given) != len(labels) {
+			log.Fatalf("%v labels given for %v files", len(given), len(labels))
+		}
+		copy(labels, given)
+	}
+
+	var groups []*histGroup
+	byKey := make(map[string]*histGroup)
+	for i, inputPath := range flag.Args() {
+		objects, err := ana.ReadHists(inputPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		byName := make(map[string]hbook.Object)
+		for _, object := range objects {
+			byName[histName(object)] = object
+		}
+
+		for _, object := range objects {
+			name := histName(object)
+			if match != nil && !match.MatchString(name) {
+				continue
+			}
+
+			key, label := name, labels[i]
+			if *overlaySets {
+				set := name
+				if j := strings.Index(name, "/"); j >= 0 {
+					set, key = name[:j], name[j+1:]
+				}
+				if len(labels) > 1 {
+					label += " " + set
+				} else {
+					label = set
+				}
+			}
+
+			g := byKey[key]
+			if g == nil {
+				g = &histGroup{key: key}
+				byKey[key] = g
+				groups = append(groups, g)
+			}
+			g.entries = append(g.entries, histEntry{label: label, object: object, file: byName})
+		}
+	}
+	if len(groups) == 0 {
+		log.Fatal("no histograms to draw")
+	}
+
+	var pages []hplot.Drawer
+	for _, g := range groups {
+		pages = append(pages, g.draw()...)
+	}
+
+	if err := ana.SavePages(pages, ana.PlotWidth, ana.PlotHeight, *outputPath); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// histName returns the name under which object was saved, without the
+// leading slash of its YODA path.
+func histName(object hbook.Object) string {
+	return strings.TrimPrefix(object.Name(), "/")
+}
+
+// histGroup are the histograms drawn together, which share a name across
+// the input files, or across the input sets with -sets.
+type histGroup struct {
+	key     string
+	entries []histEntry
+}
+
+// histEntry is a histogram of a group, with its legend label and the other
+// histograms of its file by name, among which the denominator of an
+// efficiency is found.
+type histEntry struct {
+	label  string
+	object hbook.Object
+	file   map[string]hbook.Object
+}
+
+// draw returns the pages of g: one on which its 1D histograms and scatters
+// are overlaid, and one for each of its 2D histograms.
+func (g *histGroup) draw() []hplot.Drawer {
+	var pages []hplot.Drawer
+
+	first := g.entries[0].object.Annotation()
+	p := ana.NewPlot(g.title(first), annLabel(first, ana.XLabelKey), annLabel(first, ana.YLabelKey))
+	nOverlaid := 0
+	for _, e := range g.entries {
+		style := ana.SetStyle(nOverlaid)
+		switch object := e.object.(type) {
+		case *hbook.H1D:
+			if *normalize && object.Integral() > 0 {
+				object = object.Clone()
+				object.Scale(1 / object.Integral())
+				p.Y.Label.Text = "normalized count"
+			}
+			h := hplot.NewH1D(object)
+			style.Apply(h)
+			p.Add(h)
+			p.Legend.Add(e.label, h)
+			nOverlaid++
+		case *hbook.S2D:
+			if object.Len() == 0 {
+				continue
+			}
+			h := style.NewErrorPlot(object)
+			p.Add(h)
+			p.Legend.Add(e.label, h)
+			nOverlaid++
+		case *hbook.H2D:
+			pages = append(pages, g.drawMap(e, object))
+		}
+	}
+	if nOverlaid > 0 {
+		if nOverlaid == 1 {
+			p.Legend = hplot.NewLegend()
+		}
+		pages = append([]hplot.Drawer{p}, pages...)
+	}
+	return pages
+}
+
+// drawMap returns a color map of h, the 2D histogram of e.  Efficiencies are
+// drawn on a fixed scale with a legend, blank where their denominator is
+// empty.
+func (g *histGroup) drawMap(e histEntry, h *hbook.H2D) hplot.Drawer {
+	ann := h.Annotation()
+	title := g.title(ann)
+	if len(g.entries) > 1 {
+		title += " (" + e.label + ")"
+	}
+	p := ana.NewPlot(title, annLabel(ann, ana.XLabelKey), annLabel(ann, ana.YLabelKey))
+
+	if fmt.Sprint(ann[ana.DerivedKey]) == ana.DerivedEfficiency {
+		if total, ok := e.file[fmt.Sprint(ann[ana.DenominatorKey])].(*hbook.H2D); ok {
+			m := ana.NewEfficiencyMap(h, total)
+			p.Add(m)
+
+			legend := m.Legend()
+			legend.Left = false
+			return hplot.Figure(p, hplot.WithLegend(legend))
+		}
+	}
+
+	p.Add(hplot.NewH2D(h, nil))
+	return p
+}
+
+// title returns the title saved in ann, or else the name of the group.
+func (g *histGroup) title(ann hbook.Annotation) string {
+	if title, ok := ann["title"].(string); ok && title != "" {
+		return title
+	}
+	return g.key
+}
+
+// annLabel returns the axis label saved in ann under key, or "" if there is
+// none.
+func annLabel(ann hbook.Annotation, key string) string {
+	if label, ok := ann[key]; ok {
+		return fmt.Sprint(label)
+	}
+	return ""
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path"
+
+	"go-hep.org/x/hep/hbook"
+	"go-hep.org/x/hep/hplot"
+	"go-hep.org/x/hep/lcio"
+
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+
+	"github.com/decibelcooper/SiEIC/ana"
+)
+
+var (
+	compactPath   = flag.String("compact", ana.DefaultCompactDescription, "compact detector description declaring the readouts")
+	inputsAreDirs = flag.Bool("d", false, "inputs are directories")
+	maxFailFrac   = flag.Float64("maxfail", 0, "fraction of input files that may fail to be read before exiting with status 1")
+	maxFiles      = flag.Int("m", math.MaxInt32, "maximum number of files to process")
+	nThreads      = flag.Int("t", 2, "number of concurrent files to process")
+	outputPath    = flag.String("o", "out.pdf", "path of output file")
+)
+
+var (
+	ctx context.Context
+
+	// readouts are those declared in the compact description given by
+	// -compact.
+	readouts []ana.Readout
+)
+
+const (
+	// mapPadFrac is the fraction of the extent of a readout by which its r-z
+	// map is widened on each side, to take in module and layer thicknesses
+	// beyond the extent, and the forward calorimeters centred on the outgoing
+	// beam rather than the z axis
+	mapPadFrac = 0.1
+
+	nMapBins       = 100
+	minLog10Energy = -8
+	maxLog10Energy = 1
+	nEnergyBins    = 90
+	pageWidth      = 12 * vg.Inch
+	pageHeight     = 4 * vg.Inch
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: simHits [options] <lcio-input-file>
+options:
+`,
+		)
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+	defer ana.ExitIfFailed()
+	ctx = ana.InterruptContext()
+
+	var err error
+	readouts, err = ana.ReadReadouts(*compactPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var pages []hplot.Drawer
+	if *inputsAreDirs {
+		for _, dir := range flag.Args() {
+			inputFiles, err := ana.DirFiles(dir)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			pages = append(pages, drawFileSet(inputFiles, path.Base(dir))...)
+		}
+	} else {
+		pages = drawFileSet(flag.Args(), "")
+	}
+
+	if err := ana.SavePages(pages, pageWidth, pageHeight, *outputPath); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// EventResult marks the end of an event, so that occupancies can be given
+// per event.
+type EventResult struct{}
+
+// HitResult describes a simulated hit in the readout indexed by Readout in
+// readouts, with its position in mm and its deposited energy in GeV.
+type HitResult struct {
+	Readout int
+	Layer   int
+	R       float64
+	Z       float64
+	Energy  float64
+}
+
+// hitHists accumulate the hits of every readout, indexed as readouts.
+type hitHists struct {
+	nEvents  int
+	readouts []readoutHists
+}
+
+// readoutHists accumulate the hits of one readout.
+type readoutHists struct {
+	layerCounts map[int]float64
+	energy      *hbook.H1D
+	rzMap       *hbook.H2D
+}
+
+func newHitHists() ana.Collector {
+	hists := &hitHists{readouts: make([]readoutHists, len(readouts))}
+	for i := range hists.readouts {
+		hists.readouts[i].layerCounts = make(map[int]float64)
+		hists.readouts[i].energy = hbook.NewH1D(nEnergyBins, minLog10Energy, maxLog10Energy)
+		hists.readouts[i].rzMap = newMapHist(readouts[i].Extent)
+	}
+	return hists
+}
+
+func (hists *hitHists) Collect(result interface{}) {
+	switch result := result.(type) {
+	case EventResult:
+		hists.nEvents++
+	case HitResult:
+		h := &hists.readouts[result.Readout]
+		h.layerCounts[result.Layer]++
+		if result.Energy > 0 {
+			h.energy.Fill(math.Log10(result.Energy), 1)
+		}
+		h.rzMap.Fill(result.Z, result.R, 1)
+	}
+}
+
+func (hists *hitHists) Merge(other ana.Collector) {
+	o := other.(*hitHists)
+	hists.nEvents += o.nEvents
+	for i := range hists.readouts {
+		h, oh := &hists.readouts[i], &o.readouts[i]
+		for layer, count := range oh.layerCounts {
+			h.layerCounts[layer] += count
+		}
+		ana.MergeH1D(h.energy, oh.energy)
+		ana.MergeH2D(h.rzMap, oh.rzMap)
+	}
+}
+
+// drawFileSet analyzes inputFiles and returns a page for each readout with
+// its occupancy per layer, r-z map of hit positions and deposited energy
+// spectrum.  label is appended to the titles.
+func drawFileSet(inputFiles []string, label string) []hplot.Drawer {
+	fileSet := ana.FileSet{Files: inputFiles, NThreads: *nThreads, MaxFiles: *maxFiles, MaxFailFrac: *maxFailFrac}
+	hists := fileSet.Run(ctx, analyzeEvent, newHitHists).(*hitHists)
+
+	if label != "" {
+		label = ": " + label
+	}
+
+	var pages []hplot.Drawer
+	for i, readout := range readouts {
+		h := &hists.readouts[i]
+
+		page := hplot.NewTiledPlot(draw.Tiles{
+			Rows: 1,
+			Cols: 3,
+			PadX: 5 * vg.Millimeter,
+		})
+
+		tile := page.Plot(0, 0)
+		*tile = *ana.NewPlot(readout.Name+" Occupancy"+label, "layer", "hits per event")
+		hOccupancy := hplot.NewH1D(occupancyHist(h.layerCounts, hists.nEvents))
+		ana.LineStyle{Color: ana.Blue}.Apply(hOccupancy)
+		tile.Add(hOccupancy)
+
+		tile = page.Plot(1, 0)
+		*tile = *ana.NewPlot(readout.Name+" Positions"+label, "z {mm}", "r {mm}")
+		if h.rzMap.Entries() > 0 {
+			tile.Add(hplot.NewH2D(h.rzMap, nil))
+		}
+
+		tile = page.Plot(2, 0)
+		*tile = *ana.NewPlot(readout.Name+" Deposited Energy"+label, "log10(energy / GeV)", "count")
+		hEnergy := hplot.NewH1D(h.energy)
+		ana.LineStyle{Color: ana.Blue}.Apply(hEnergy)
+		if !*inputsAreDirs {
+			hEnergy.Infos.Style = hplot.HInfoSummary
+		}
+		tile.Add(hEnergy)
+
+		pages = append(pages, page)
+	}
+	return pages
+}
+
+// occupancyHist returns a histogram of the hits per event in each layer,
+// given the hit count of each layer over nEvents events.
+func occupancyHist(layerCounts map[int]float64, nEvents int) *hbook.H1D {
+	maxLayer := 0
+	for layer := range layerCounts {
+		if layer > maxLayer {
+			maxLayer = layer
+		}
+	}
+
+	h := hbook.NewH1D(maxLayer+1, -0.5, float64(maxLayer)+0.5)
+	if nEvents == 0 {
+		return h
+	}
+	for layer, count := range layerCounts {
+		h.Fill(float64(layer), count/float64(nEvents))
+	}
+	return h
+}
+
+// newMapHist returns an r-z map histogram, with z along x, spanning the
+// extent ext widened by mapPadFrac on each side.
+func newMapHist(ext ana.Extent) *hbook.H2D {
+	zPad := mapPadFrac * (ext.ZMax - ext.ZMin)
+	rPad := mapPadFrac * (ext.RMax - ext.RMin)
+	return hbook.NewH2D(
+		nMapBins, ext.ZMin-zPad, ext.ZMax+zPad,
+		nMapBins, math.Max(0, ext.RMin-rPad), ext.RMax+rPad,
+	)
+}
+
+func analyzeEvent(event *lcio.Event, out func(result interface{})) error {
+	for i, readout := range readouts {
+		switch coll := event.Get(readout.Name).(type) {
+		case *lcio.SimTrackerHitContainer:
+			decoder := newDecoder(coll.Params, readout)
+			for j := range coll.Hits {
+				hit := &coll.Hits[j]
+				out(HitResult{
+					Readout: i,
+					Layer:   int(decoder.Get(hit, "layer")),
+					R:       math.Hypot(hit.Pos[0], hit.Pos[1]),
+					Z:       hit.Pos[2],
+					Energy:  float64(hit.EDep),
+				})
+			}
+		case *lcio.SimCalorimeterHitContainer:
+			decoder := newDecoder(coll.Params, readout)
+			for j := range coll.Hits {
+				hit := &coll.Hits[j]
+				out(HitResult{
+					Readout: i,
+					Layer:   int(decoder.Get(hit, "layer")),
+					R:       math.Hypot(float64(hit.Pos[0]), float64(hit.Pos[1])),
+					Z:       float64(hit.Pos[2]),
+					Energy:  float64(hit.Energy),
+				})
+			}
+		}
+	}
+	out(EventResult{})
+	return nil
+}
+
+// newDecoder returns a decoder for the cell IDs of a hit collection of
+// readout with the given parameters, preferring the encoding written with
+// the collection to the one declared in the compact description.
+func newDecoder(params lcio.Params, readout ana.Readout) *lcio.CellIDDecoder {
+	if decoder := lcio.NewCellIDDecoderFrom(params); decoder != nil {
+		return decoder
+	}
+	return lcio.NewCellIDDecoder(readout.CellIDEncoding)
+}
@@ -1,35 +1,62 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"image/color"
-	"io/ioutil"
 	"log"
 	"math"
 	"os"
 	"path"
-	"time"
+	"strings"
 
 	"go-hep.org/x/hep/hbook"
 	"go-hep.org/x/hep/hplot"
 	"go-hep.org/x/hep/lcio"
 
+	"gonum.org/v1/plot"
 	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+
+	"github.com/decibelcooper/SiEIC/ana"
 )
 
 var (
-	doMinAnglePlot   = flag.Bool("a", false, "generate plot of minimum angle between Tracks and MCParticles")
-	inputsAreDirs    = flag.Bool("d", false, "inputs are directories")
-	maxFiles         = flag.Int("m", math.MaxInt32, "maximum number of files to process")
-	normalize        = flag.Bool("n", false, "normalize Track count to MCParticle count")
-	nThreads         = flag.Int("t", 2, "number of concurrent files to process")
-	outputPath       = flag.String("o", "out.pdf", "path of output file")
-	showTrackSummary = flag.Bool("s", false, "show stats summary for track distribution")
-	vsP_T            = flag.Bool("p", false, "plot efficiency vs. p_T")
+	crossingAngleSource = flag.String("xangle", ana.DefaultCrossingAngleSource, "compact detector description or SLIC macro defining the crossing angle")
+	frameName           = flag.String("frame", "lab", "frame in which to histogram kinematics: lab, or headon to remove the crossing-angle boost")
+	doCloneRate         = flag.Bool("c", false, "plot rate of MCParticles matched by more than one Track")
+	doEffMap            = flag.Bool("map", false, "plot efficiency map in eta and p_T, one page per input set")
+	doFakeRate          = flag.Bool("f", false, "plot rate of Tracks not matched to any MCParticle")
+	doMinAnglePlot      = flag.Bool("a", false, "generate plot of minimum angle between Tracks and MCParticles")
+	doResolution        = flag.Bool("r", false, "plot track parameter residuals, pulls and resolutions, one page per parameter")
+	inputsAreDirs       = flag.Bool("d", false, "inputs are directories")
+	intervalName        = flag.String("ci", "clopper-pearson", "binomial interval for efficiencies and rates: clopper-pearson or wilson")
+	logP_T              = flag.Bool("logpt", false, "use log-spaced p_T bins and a log p_T axis in the efficiency map")
+	mapEtaBins          = flag.Int("etabins", 20, "number of eta bins in the efficiency map")
+	mapMaxP_T           = flag.Float64("ptmax", maxP_T, "maximum p_T in the efficiency map")
+	mapP_TBins          = flag.Int("ptbins", 20, "number of p_T bins in the efficiency map")
+	matchMode           = flag.String("match", "angle", "track-to-MCParticle matching: angle (minimum opening angle) or hits (majority of hits via LCRelations)")
+	maxFailFrac         = flag.Float64("maxfail", 0, "fraction of input files that may fail to be read before exiting with status 1")
+	maxFiles            = flag.Int("m", math.MaxInt32, "maximum number of files to process")
+	mcName              = flag.String("mc", ana.DefaultMCParticles, "name of the MCParticle collection")
+	minPurity           = flag.Float64("purity", 0.5, "minimum fraction of track hits from the matched MCParticle in hits matching")
+	normalize           = flag.Bool("n", false, "normalize Track count to MCParticle count")
+	nThreads            = flag.Int("t", 2, "number of concurrent files to process")
+	outputPath          = flag.String("o", "out.pdf", "path of output file")
+	relationNames       = flag.String("rel", strings.Join(ana.DefaultTrackerHitRelations, ","), "comma-separated LCRelation collections followed in hits matching")
+	showTrackSummary    = flag.Bool("s", false, "show stats summary for track distribution")
+	trackName           = flag.String("tracks", ana.DefaultTracks, "name of the Track collection")
+	vsP_T               = flag.Bool("p", false, "plot efficiency vs. p_T")
+)
+
+var (
+	ctx        context.Context
+	frame      ana.Frame
+	savedHists ana.Hists
 )
 
 const (
+	bField     = 2.5 // Tesla, from compact_dd4hep.xml
 	maxAngle   = 0.01
 	minEta     = -5
 	maxEta     = 5
@@ -39,6 +66,36 @@ const (
 	nAngleBins = 50
 	nP_TBins   = 50
 	truthMinPT = 0.5
+
+	nResEtaBins   = 10
+	nResP_TBins   = 9
+	nResidualBins = 100
+	nPullBins     = 100
+	maxPull       = 5
+	minFitEntries = 50
+	fitCoreNSigma = 2
+	resPageWidth  = 10 * vg.Inch
+	resPageHeight = 7 * vg.Inch
+)
+
+type trackParam int
+
+const (
+	invP_TParam trackParam = iota
+	d0Param
+	z0Param
+	phiParam
+	tanLParam
+	nTrackParams
+)
+
+var (
+	trackParamNames = [nTrackParams]string{"1/p_T", "d0", "z0", "phi", "tan(lambda)"}
+	trackParamKeys  = [nTrackParams]string{"invP_T", "d0", "z0", "phi", "tanL"}
+	trackParamUnits = [nTrackParams]string{" {1/GeV}", " {mm}", " {mm}", "", ""}
+
+	// maxResiduals are the half-ranges of the residual histograms
+	maxResiduals = [nTrackParams]float64{0.02, 0.1, 0.1, 0.005, 0.005}
 )
 
 func main() {
@@ -51,66 +108,101 @@ options:
 	}
 
 	flag.Parse()
+	defer ana.ExitIfFailed()
+	ctx = ana.InterruptContext()
+
+	var err error
+	frame, err = ana.NewFrame(*frameName, *crossingAngleSource)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	p := hplot.New()
+	switch *matchMode {
+	case "hits", "angle":
+	default:
+		log.Fatalf("unknown matching mode %q", *matchMode)
+	}
+
+	interval, err := ana.ParseInterval(*intervalName)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	p.Title.Text = "Tracking/Truth Comparison"
-	if *normalize {
-		p.Title.Text = "Tracking Efficiency"
-	} else if *inputsAreDirs {
-		p.Title.Text = "Tracking Comparison"
+	title := "Tracking/Truth Comparison"
+	yLabel := ""
+	switch {
+	case *doFakeRate:
+		title = "Fake Track Rate"
+		yLabel = "fake rate"
+	case *doCloneRate:
+		title = "Duplicate Track Rate"
+		yLabel = "duplicate rate"
+	case *normalize:
+		title = "Tracking Efficiency"
+	case *inputsAreDirs:
+		title = "Tracking Comparison"
 	}
-	p.Title.Padding = 2 * vg.Millimeter
-	p.Legend.Left = true
-	p.Legend.Top = true
-	p.Legend.Padding = 2 * vg.Millimeter
-	p.X.Label.Text = "eta"
+	xLabel := "eta"
 	if *doMinAnglePlot {
-		p.Legend.Left = false
-		p.X.Label.Text = "min. angular deviation"
+		xLabel = "min. angular deviation"
 	} else if *vsP_T {
-		p.X.Label.Text = "p_T {GeV}"
+		xLabel = "p_T {GeV}"
+	}
+
+	p := ana.NewPlot(title, xLabel, yLabel)
+	if *doMinAnglePlot {
+		p.Legend.Left = false
 	}
 
+	var resPages []*hplot.TiledPlot
+	if *doResolution {
+		resPages = newResolutionPages()
+	}
+	var mapPages []hplot.Drawer
+
 	if *inputsAreDirs {
 		for i, dir := range flag.Args() {
-			files, err := ioutil.ReadDir(dir)
+			inputFiles, err := ana.DirFiles(dir)
 			if err != nil {
 				log.Fatal(err)
 			}
 
-			var inputFiles []string
-			for _, file := range files {
-				inputFiles = append(inputFiles, dir+"/"+file.Name())
-			}
-
-			trackColor := color.RGBA{R: 255, B: 255, G: 255, A: 255}
-			var dashes []vg.Length
-			var dashOffs vg.Length
-			switch i {
-			case 0:
-				trackColor = color.RGBA{B: 255, A: 255}
-			case 1:
-				trackColor = color.RGBA{R: 255, A: 255}
-				dashes = append(dashes, 1*vg.Millimeter)
-			case 2:
-				trackColor = color.RGBA{G: 255, A: 255}
-				dashes = append(dashes, 1*vg.Millimeter)
-				dashOffs = 1 * vg.Millimeter
+			mapPage := drawFileSet(inputFiles, p, resPages, false, ana.SetStyle(i), path.Base(dir), interval)
+			if mapPage != nil {
+				mapPages = append(mapPages, mapPage)
 			}
-
-			drawFileSet(inputFiles, p, false, trackColor, path.Base(dir), dashes, dashOffs)
 		}
 	} else {
-		histColor := color.RGBA{R: 255, A: 255}
-		if *normalize || *doMinAnglePlot {
-			histColor = color.RGBA{B: 255, A: 255}
+		histColor := ana.Red
+		if *normalize || *doMinAnglePlot || *doFakeRate || *doCloneRate || *doResolution {
+			histColor = ana.Blue
 		}
 
-		drawFileSet(flag.Args(), p, true, histColor, "Track", nil, 0)
+		mapPage := drawFileSet(flag.Args(), p, resPages, true, ana.LineStyle{Color: histColor}, "Track", interval)
+		if mapPage != nil {
+			mapPages = append(mapPages, mapPage)
+		}
+	}
+
+	switch {
+	case *doEffMap:
+		err = ana.SavePages(mapPages, ana.PlotWidth, ana.PlotHeight, *outputPath)
+	case *doResolution:
+		pages := make([]hplot.Drawer, len(resPages))
+		for i, page := range resPages {
+			pages[i] = page
+		}
+		err = ana.SavePages(pages, resPageWidth, resPageHeight, *outputPath)
+	default:
+		err = ana.SavePlot(p, *outputPath)
+	}
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	p.Save(6*vg.Inch, 4*vg.Inch, *outputPath)
+	if err := savedHists.Save(*outputPath); err != nil {
+		log.Fatal(err)
+	}
 }
 
 type TrueResult struct {
@@ -118,219 +210,555 @@ type TrueResult struct {
 	P_T float64
 }
 
+// TrackResult describes a Track matched to a previously unmatched MCParticle,
+// in terms of the MCParticle kinematics.
 type TrackResult struct {
 	MinAngle float64
 	Eta      float64
 	P_T      float64
 }
 
-func drawFileSet(inputFiles []string, p *hplot.Plot, drawTruth bool, trackColor color.Color, trackLabel string, trackDashes []vg.Length, trackDashOffs vg.Length) {
-	trueEtaHist := hbook.NewH1D(nEtaBins, minEta, maxEta)
-	trackEtaHist := hbook.NewH1D(nEtaBins, minEta, maxEta)
-	minAngleHist := hbook.NewH1D(nAngleBins, 0, maxAngle)
-	trueP_THist := hbook.NewH1D(nP_TBins, minP_T, maxP_T)
-	trackP_THist := hbook.NewH1D(nP_TBins, minP_T, maxP_T)
+// RecoResult describes every Track in terms of its own kinematics, and
+// whether it failed to match any MCParticle.
+type RecoResult struct {
+	Eta  float64
+	P_T  float64
+	Fake bool
+}
+
+// ResolutionResult holds the residuals and pulls of the parameters of a Track
+// with respect to its matched MCParticle, indexed by trackParam.
+type ResolutionResult struct {
+	Eta      float64
+	P_T      float64
+	Residual [nTrackParams]float64
+	Pull     [nTrackParams]float64
+}
+
+// CloneResult describes an MCParticle matched by more than one Track.
+type CloneResult struct {
+	Eta float64
+	P_T float64
+}
+
+// trackHists accumulate the results of one worker.
+type trackHists struct {
+	trueEta, trackEta, recoEta, fakeEta, cloneEta *hbook.H1D
+	trueP_T, trackP_T, recoP_T, fakeP_T, cloneP_T *hbook.H1D
+	minAngle                                      *hbook.H1D
+	trueMap, trackMap                             *hbook.H2D
+	res                                           *resolutionHists
+}
 
-	trueResults := make(chan TrueResult)
-	trackResults := make(chan TrackResult)
-	done := make(chan bool)
+func newTrackHists() ana.Collector {
+	mapEtaEdges := ana.LinearEdges(minEta, maxEta, *mapEtaBins)
+	mapP_TEdges := ana.LinearEdges(truthMinPT, *mapMaxP_T, *mapP_TBins)
+	if *logP_T {
+		mapP_TEdges = ana.LogEdges(truthMinPT, *mapMaxP_T, *mapP_TBins)
+	}
 
-	nFilesToAnalyze := len(inputFiles)
-	if *maxFiles < nFilesToAnalyze {
-		nFilesToAnalyze = *maxFiles
+	return &trackHists{
+		trueEta:  hbook.NewH1D(nEtaBins, minEta, maxEta),
+		trackEta: hbook.NewH1D(nEtaBins, minEta, maxEta),
+		recoEta:  hbook.NewH1D(nEtaBins, minEta, maxEta),
+		fakeEta:  hbook.NewH1D(nEtaBins, minEta, maxEta),
+		cloneEta: hbook.NewH1D(nEtaBins, minEta, maxEta),
+		trueP_T:  hbook.NewH1D(nP_TBins, minP_T, maxP_T),
+		trackP_T: hbook.NewH1D(nP_TBins, minP_T, maxP_T),
+		recoP_T:  hbook.NewH1D(nP_TBins, minP_T, maxP_T),
+		fakeP_T:  hbook.NewH1D(nP_TBins, minP_T, maxP_T),
+		cloneP_T: hbook.NewH1D(nP_TBins, minP_T, maxP_T),
+		minAngle: hbook.NewH1D(nAngleBins, 0, maxAngle),
+		trueMap:  hbook.NewH2DFromEdges(mapEtaEdges, mapP_TEdges),
+		trackMap: hbook.NewH2DFromEdges(mapEtaEdges, mapP_TEdges),
+		res:      newResolutionHists(),
 	}
+}
 
-	nSubmitted := 0
-	nDone := 0
+func (h *trackHists) Collect(result interface{}) {
+	switch result := result.(type) {
+	case TrueResult:
+		h.trueEta.Fill(result.Eta, 1)
+		h.trueP_T.Fill(result.P_T, 1)
+		h.trueMap.Fill(result.Eta, result.P_T, 1)
+	case TrackResult:
+		h.trackEta.Fill(result.Eta, 1)
+		h.minAngle.Fill(result.MinAngle, 1)
+		h.trackP_T.Fill(result.P_T, 1)
+		h.trackMap.Fill(result.Eta, result.P_T, 1)
+	case RecoResult:
+		h.recoEta.Fill(result.Eta, 1)
+		h.recoP_T.Fill(result.P_T, 1)
+		if result.Fake {
+			h.fakeEta.Fill(result.Eta, 1)
+			h.fakeP_T.Fill(result.P_T, 1)
+		}
+	case CloneResult:
+		h.cloneEta.Fill(result.Eta, 1)
+		h.cloneP_T.Fill(result.P_T, 1)
+	case ResolutionResult:
+		h.res.fill(result)
+	}
+}
 
-	for nSubmitted < nFilesToAnalyze && nSubmitted < *nThreads {
-		go analyzeFile(inputFiles[nSubmitted], trueResults, trackResults, done)
-		nSubmitted++
+func (h *trackHists) Merge(other ana.Collector) {
+	o := other.(*trackHists)
+	ana.MergeH1Ds(
+		[]*hbook.H1D{h.trueEta, h.trackEta, h.recoEta, h.fakeEta, h.cloneEta, h.trueP_T, h.trackP_T, h.recoP_T, h.fakeP_T, h.cloneP_T, h.minAngle},
+		[]*hbook.H1D{o.trueEta, o.trackEta, o.recoEta, o.fakeEta, o.cloneEta, o.trueP_T, o.trackP_T, o.recoP_T, o.fakeP_T, o.cloneP_T, o.minAngle},
+	)
+	ana.MergeH2D(h.trueMap, o.trueMap)
+	ana.MergeH2D(h.trackMap, o.trackMap)
+	h.res.merge(o.res)
+}
 
-		time.Sleep(time.Millisecond)
+// save adds the histograms to savedHists under set, along with the
+// efficiencies and rates drawn from them.
+func (h *trackHists) save(set string, interval ana.Interval) {
+	vars := []struct {
+		name, xLabel                                       string
+		trueHist, trackHist, recoHist, fakeHist, cloneHist *hbook.H1D
+	}{
+		{"Eta", "eta", h.trueEta, h.trackEta, h.recoEta, h.fakeEta, h.cloneEta},
+		{"P_T", "p_T {GeV}", h.trueP_T, h.trackP_T, h.recoP_T, h.fakeP_T, h.cloneP_T},
+	}
+	for _, v := range vars {
+		savedHists.Add(set+"/true"+v.name, v.trueHist, v.xLabel, "count")
+		savedHists.Add(set+"/track"+v.name, v.trackHist, v.xLabel, "count")
+		savedHists.Add(set+"/reco"+v.name, v.recoHist, v.xLabel, "count")
+		savedHists.Add(set+"/fake"+v.name, v.fakeHist, v.xLabel, "count")
+		savedHists.Add(set+"/clone"+v.name, v.cloneHist, v.xLabel, "count")
+
+		savedHists.AddEfficiency(set+"/eff"+v.name, set+"/track"+v.name, set+"/true"+v.name, interval, "efficiency")
+		savedHists.AddEfficiency(set+"/fakeRate"+v.name, set+"/fake"+v.name, set+"/reco"+v.name, interval, "fake rate")
+		savedHists.AddEfficiency(set+"/cloneRate"+v.name, set+"/clone"+v.name, set+"/track"+v.name, interval, "duplicate rate")
 	}
 
-	for nDone < nSubmitted {
-		select {
-		case trueResult := <-trueResults:
-			trueEtaHist.Fill(trueResult.Eta, 1)
-			trueP_THist.Fill(trueResult.P_T, 1)
-		case trackResult := <-trackResults:
-			trackEtaHist.Fill(trackResult.Eta, 1)
-			minAngleHist.Fill(trackResult.MinAngle, 1)
-			trackP_THist.Fill(trackResult.P_T, 1)
-		case <-done:
-			nDone++
+	savedHists.Add(set+"/minAngle", h.minAngle, "min. angular deviation", "count")
+	savedHists.Add(set+"/trueMap", h.trueMap, "eta", "p_T {GeV}")
+	savedHists.Add(set+"/trackMap", h.trackMap, "eta", "p_T {GeV}")
+	savedHists.AddEfficiency(set+"/effMap", set+"/trackMap", set+"/trueMap", interval, "")
 
-			if nSubmitted < nFilesToAnalyze {
-				go analyzeFile(inputFiles[nSubmitted], trueResults, trackResults, done)
-				nSubmitted++
-			}
-		}
+	if *doResolution {
+		h.res.save(set)
+	}
+}
+
+// drawFileSet analyzes inputFiles and adds the resulting distributions to p,
+// or to resPages in resolution mode.  In efficiency map mode, it instead
+// returns a page holding the map of this file set.
+func drawFileSet(inputFiles []string, p *hplot.Plot, resPages []*hplot.TiledPlot, drawTruth bool, trackStyle ana.LineStyle, trackLabel string, interval ana.Interval) hplot.Drawer {
+	fileSet := ana.FileSet{Files: inputFiles, NThreads: *nThreads, MaxFiles: *maxFiles, MaxFailFrac: *maxFailFrac}
+	hists := fileSet.Run(ctx, analyzeEvent, newTrackHists).(*trackHists)
+	hists.save(trackLabel, interval)
+
+	if *doEffMap {
+		return drawEfficiencyMap(hists.trackMap, hists.trueMap, trackLabel)
+	}
+
+	if *doResolution {
+		hists.res.draw(resPages, trackStyle, trackLabel)
+		return nil
 	}
 
 	if *doMinAnglePlot {
-		h := hplot.NewH1D(minAngleHist)
-		h.LineStyle.Color = trackColor
-		h.LineStyle.Dashes = trackDashes
-		h.LineStyle.DashOffs = trackDashOffs
+		h := hplot.NewH1D(hists.minAngle)
+		trackStyle.Apply(h)
 		p.Add(h)
 		if *inputsAreDirs {
 			p.Legend.Add(trackLabel, h)
 		}
-	} else {
-		var hTrue *hplot.H1D
-		if drawTruth {
-			if !*vsP_T {
-				hTrue = hplot.NewH1D(trueEtaHist)
-			} else {
-				hTrue = hplot.NewH1D(trueP_THist)
-			}
-			hTrue.LineStyle.Color = color.RGBA{B: 255, A: 255}
-			if !*normalize {
-				p.Add(hTrue)
-				p.Legend.Add("MCParticle", hTrue)
-			}
-		}
+		return nil
+	}
 
-		var hTrack *hplot.H1D
-		if !*vsP_T {
-			hTrack = hplot.NewH1D(trackEtaHist)
-		} else {
-			hTrack = hplot.NewH1D(trackP_THist)
-		}
-		hTrack.LineStyle.Color = trackColor
-		hTrack.LineStyle.Dashes = trackDashes
-		hTrack.LineStyle.DashOffs = trackDashOffs
-		if *showTrackSummary {
-			hTrack.Infos.Style = hplot.HInfoSummary
-		}
-		if !*normalize {
-			p.Add(hTrack)
-			p.Legend.Add(trackLabel, hTrack)
-		}
+	trueHist, trackHist := hists.trueEta, hists.trackEta
+	recoHist, fakeHist, cloneHist := hists.recoEta, hists.fakeEta, hists.cloneEta
+	if *vsP_T {
+		trueHist, trackHist = hists.trueP_T, hists.trackP_T
+		recoHist, fakeHist, cloneHist = hists.recoP_T, hists.fakeP_T, hists.cloneP_T
+	}
 
-		normHist := hbook.NewH1D(hTrue.Hist.Len(), hTrue.Hist.XMin(), hTrue.Hist.XMax())
-		if *normalize {
-			for i := 0; i < normHist.Len(); i++ {
-				trueX, trueY := hTrue.Hist.XY(i)
-				_, trackY := hTrack.Hist.XY(i)
-				if trueY > 0 {
-					normHist.Fill(trueX, trackY/trueY)
-				}
-			}
+	var numHist, denHist *hbook.H1D
+	switch {
+	case *doFakeRate:
+		numHist, denHist = fakeHist, recoHist
+	case *doCloneRate:
+		numHist, denHist = cloneHist, trackHist
+	case *normalize:
+		numHist, denHist = trackHist, trueHist
+	}
 
-			hNorm := hplot.NewH1D(normHist)
-			hNorm.LineStyle.Color = trackColor
-			hNorm.LineStyle.Dashes = trackDashes
-			hNorm.LineStyle.DashOffs = trackDashOffs
-			p.Add(hNorm)
-			if *inputsAreDirs {
-				p.Legend.Add(trackLabel, hNorm)
-			}
+	if numHist != nil {
+		hRatio := trackStyle.NewErrorPlot(ana.Efficiency(numHist, denHist, interval))
+		p.Add(hRatio)
+		if *inputsAreDirs {
+			p.Legend.Add(trackLabel, hRatio)
 		}
+		return nil
+	}
+
+	if drawTruth {
+		hTrue := hplot.NewH1D(trueHist)
+		hTrue.LineStyle.Color = ana.Blue
+		p.Add(hTrue)
+		p.Legend.Add("MCParticle", hTrue)
+	}
+
+	hTrack := hplot.NewH1D(trackHist)
+	trackStyle.Apply(hTrack)
+	if *showTrackSummary {
+		hTrack.Infos.Style = hplot.HInfoSummary
+	}
+	p.Add(hTrack)
+	p.Legend.Add(trackLabel, hTrack)
+	return nil
+}
+
+// drawEfficiencyMap returns a figure of the tracking efficiency in bins of
+// eta and p_T, with its color scale.
+func drawEfficiencyMap(trackMapHist, trueMapHist *hbook.H2D, label string) hplot.Drawer {
+	title := "Tracking Efficiency"
+	if *inputsAreDirs {
+		title += ": " + label
 	}
+
+	p := ana.NewPlot(title, "eta", "p_T {GeV}")
+	if *logP_T {
+		p.Y.Scale = plot.LogScale{}
+		p.Y.Tick.Marker = plot.LogTicks{Prec: -1}
+	}
+
+	effMap := ana.NewEfficiencyMap(ana.Efficiency2D(trackMapHist, trueMapHist), trueMapHist)
+	p.Add(effMap)
+
+	legend := effMap.Legend()
+	legend.Left = false
+	return hplot.Figure(p, hplot.WithLegend(legend))
 }
 
 type TruthRelation struct {
-	Truth *lcio.McParticle
-	PNorm [3]float64
-	Eta   float64
-	P_T   float64
+	Truth   *lcio.McParticle
+	P       ana.Vec3
+	Eta     float64
+	P_T     float64
+	NTracks int
 }
 
-func analyzeFile(inputPath string, trueResults chan<- TrueResult, trackResults chan<- TrackResult, done chan<- bool) {
-	reader, err := lcio.Open(inputPath)
+func analyzeEvent(event *lcio.Event, out func(result interface{})) error {
+	truthColl, err := ana.MCParticles(event, *mcName)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	trackColl, err := ana.Tracks(event, *trackName)
+	if err != nil {
+		return err
+	}
+
+	var hitTruth *ana.HitTruth
+	if *matchMode == "hits" {
+		var nRelColls int
+		hitTruth, nRelColls = ana.NewHitTruth(event, strings.Split(*relationNames, ","))
+		if nRelColls == 0 {
+			return fmt.Errorf("no tracker hit relation collections (%v); use -match angle", *relationNames)
+		}
 	}
-	defer reader.Close()
 
-	for reader.Next() {
-		event := reader.Event()
+	var truthRelations []TruthRelation
+	for i, truth := range truthColl.Particles {
+		if truth.GenStatus != 1 || truth.Charge == float32(0) {
+			continue
+		}
+
+		p := frame.Momentum(ana.Vec3(truth.P), truth.Energy())
+		eta := p.Eta()
+		pT := p.Perp()
+
+		if pT > truthMinPT {
+			truthRelations = append(truthRelations, TruthRelation{
+				Truth: &truthColl.Particles[i],
+				P:     p,
+				Eta:   eta,
+				P_T:   pT,
+			})
+
+			out(TrueResult{
+				Eta: eta,
+				P_T: pT,
+			})
+		}
+	}
+
+	for i := range trackColl.Tracks {
+		track := &trackColl.Tracks[i]
+		// the track mass is unknown, so treat it as massless when
+		// transforming into the analysis frame
+		pT := ana.TrackPT(track.Omega(), bField)
+		pMag := pT * math.Sqrt(1+track.TanL()*track.TanL())
+		p := frame.Momentum(ana.TrackDirection(track.TanL(), track.Phi()).Scale(pMag), pMag)
+		direction := p.Unit()
+
+		var matchIndex int
+		var matchAngle float64
+		if hitTruth != nil {
+			matchIndex, matchAngle = matchByHits(track, direction, truthRelations, hitTruth)
+		} else {
+			matchIndex, matchAngle = matchByAngle(direction, truthRelations)
+		}
+
+		isFake := false
+		switch {
+		case matchIndex < 0:
+			isFake = hitTruth == nil || !hasTruth(track, hitTruth)
+		case truthRelations[matchIndex].NTracks == 0:
+			out(TrackResult{
+				MinAngle: matchAngle,
+				Eta:      truthRelations[matchIndex].Eta,
+				P_T:      truthRelations[matchIndex].P_T,
+			})
+
+			if *doResolution {
+				out(resolutionResult(track, &truthRelations[matchIndex]))
+			}
+		}
+		if matchIndex >= 0 {
+			truthRelations[matchIndex].NTracks++
+		}
 
-		truthColl := event.Get("MCParticle").(*lcio.McParticleContainer)
-		trackColl := event.Get("Tracks").(*lcio.TrackContainer)
+		out(RecoResult{
+			Eta:  p.Eta(),
+			P_T:  p.Perp(),
+			Fake: isFake,
+		})
+	}
 
-		// FIXME: boost back from crossing angle?
+	for _, truthRelation := range truthRelations {
+		if truthRelation.NTracks > 1 {
+			out(CloneResult{
+				Eta: truthRelation.Eta,
+				P_T: truthRelation.P_T,
+			})
+		}
+	}
+	return nil
+}
 
-		var truthRelations []TruthRelation
-		for i, truth := range truthColl.Particles {
-			if truth.GenStatus != 1 || truth.Charge == float32(0) {
+// matchByAngle returns the index of the truth relation with the smallest
+// opening angle to the track direction, provided it is below maxAngle, along
+// with that angle.  MCParticles already matched to a Track are only
+// considered if no unmatched one is close enough, in which case the Track is
+// a duplicate.  The index is -1 if there is no match.
+func matchByAngle(direction ana.Vec3, truthRelations []TruthRelation) (int, float64) {
+	for _, matched := range []bool{false, true} {
+		minAngle := math.Inf(1)
+		minIndex := -1
+		for i, truthRelation := range truthRelations {
+			if (truthRelation.NTracks > 0) != matched {
 				continue
 			}
 
-			pNorm := normalizeVector(truth.P)
-			eta := math.Atanh(pNorm[2])
-			pT := math.Sqrt(truth.P[0]*truth.P[0] + truth.P[1]*truth.P[1])
-
-			if pT > truthMinPT {
-				truthRelations = append(truthRelations, TruthRelation{
-					Truth: &truthColl.Particles[i],
-					PNorm: pNorm,
-					Eta:   eta,
-					P_T:   pT,
-				})
-
-				trueResults <- TrueResult{
-					Eta: eta,
-					P_T: pT,
-				}
+			angle := direction.Angle(truthRelation.P)
+			if angle < minAngle {
+				minAngle = angle
+				minIndex = i
 			}
 		}
 
-		for _, track := range trackColl.Tracks {
-			tanLambda := track.TanL()
-			//eta := -math.Log(math.Sqrt(1+tanLambda*tanLambda) - tanLambda)
-
-			lambda := math.Atan(tanLambda)
-			px := math.Cos(track.Phi()) * math.Cos(lambda)
-			py := math.Sin(track.Phi()) * math.Cos(lambda)
-			pz := math.Sin(lambda)
-
-			pNorm := [3]float64{px, py, pz}
-
-			minAngle := math.Inf(1)
-			minIndex := -1
-			for i, truthRelation := range truthRelations {
-				angle := math.Acos(dotProduct(pNorm, truthRelation.PNorm))
-				if angle < minAngle {
-					minAngle = angle
-					minIndex = i
-				}
-			}
+		if minIndex >= 0 && minAngle < maxAngle {
+			return minIndex, minAngle
+		}
+	}
+	return -1, math.Inf(1)
+}
 
-			if minIndex >= 0 && minAngle < maxAngle {
-				trackResults <- TrackResult{
-					MinAngle: minAngle,
-					Eta:      truthRelations[minIndex].Eta,
-					P_T:      truthRelations[minIndex].P_T,
-				}
+// matchByHits returns the index of the truth relation for the MCParticle that
+// contributed the majority of the track's hits, provided the hit purity
+// reaches minPurity, along with the opening angle between the two.  The index
+// is -1 if there is no match.
+func matchByHits(track *lcio.Track, direction ana.Vec3, truthRelations []TruthRelation, hitTruth *ana.HitTruth) (int, float64) {
+	particle, purity := hitTruth.MatchTrack(track)
+	if particle == nil || purity < *minPurity {
+		return -1, math.Inf(1)
+	}
 
-				truthRelations = append(truthRelations[:minIndex], truthRelations[minIndex+1:]...)
-			}
+	for i, truthRelation := range truthRelations {
+		if truthRelation.Truth == particle {
+			return i, direction.Angle(truthRelation.P)
+		}
+	}
+	return -1, math.Inf(1)
+}
+
+// hasTruth reports whether track is made up predominantly of hits from a
+// single MCParticle, whether or not that particle passes the truth selection.
+func hasTruth(track *lcio.Track, hitTruth *ana.HitTruth) bool {
+	particle, purity := hitTruth.MatchTrack(track)
+	return particle != nil && purity >= *minPurity
+}
+
+// resolutionResult compares the parameters of track to those of the
+// MCParticle it is matched to.  The helices are those of the lab frame, in
+// which the solenoid field is defined, so the residuals are binned in the
+// lab-frame eta and p_T of the MCParticle whatever the -frame flag.
+func resolutionResult(track *lcio.Track, truthRelation *TruthRelation) ResolutionResult {
+	helix, errs := ana.TrackHelix(track)
+	trueHelix := ana.ParticleHelix(truthRelation.Truth, bField)
+
+	trueP := ana.Vec3(truthRelation.Truth.P)
+	result := ResolutionResult{
+		Eta: trueP.Eta(),
+		P_T: trueP.Perp(),
+	}
+	result.Residual[invP_TParam] = helix.InvPT(bField) - trueHelix.InvPT(bField)
+	result.Residual[d0Param] = helix.D0 - trueHelix.D0
+	result.Residual[z0Param] = helix.Z0 - trueHelix.Z0
+	result.Residual[phiParam] = ana.DeltaPhi(helix.Phi, trueHelix.Phi)
+	result.Residual[tanLParam] = helix.TanL - trueHelix.TanL
+
+	sigmas := [nTrackParams]float64{
+		ana.Helix{Omega: errs.Omega}.InvPT(bField),
+		errs.D0,
+		errs.Z0,
+		errs.Phi,
+		errs.TanL,
+	}
+	for i, sigma := range sigmas {
+		result.Pull[i] = math.NaN()
+		if sigma > 0 {
+			result.Pull[i] = result.Residual[i] / sigma
 		}
 	}
 
-	done <- true
+	return result
+}
+
+// resolutionHists holds the residual and pull distributions of each track
+// parameter, inclusively and in bins of lab-frame truth eta and p_T.
+type resolutionHists struct {
+	residual [nTrackParams]*hbook.H1D
+	pull     [nTrackParams]*hbook.H1D
+	vsEta    [nTrackParams][nResEtaBins]*hbook.H1D
+	vsP_T    [nTrackParams][nResP_TBins]*hbook.H1D
+}
+
+func newResolutionHists() *resolutionHists {
+	r := &resolutionHists{}
+	for i := range r.residual {
+		r.residual[i] = hbook.NewH1D(nResidualBins, -maxResiduals[i], maxResiduals[i])
+		r.pull[i] = hbook.NewH1D(nPullBins, -maxPull, maxPull)
+		for j := range r.vsEta[i] {
+			r.vsEta[i][j] = hbook.NewH1D(nResidualBins, -maxResiduals[i], maxResiduals[i])
+		}
+		for j := range r.vsP_T[i] {
+			r.vsP_T[i][j] = hbook.NewH1D(nResidualBins, -maxResiduals[i], maxResiduals[i])
+		}
+	}
+	return r
 }
 
-func normalizeVector(vector [3]float64) [3]float64 {
-	normFactor := math.Sqrt(dotProduct(vector, vector))
-	for i, value := range vector {
-		vector[i] = value / normFactor
+func (r *resolutionHists) fill(result ResolutionResult) {
+	etaBin := int(math.Floor(float64(nResEtaBins) * (result.Eta - minEta) / (maxEta - minEta)))
+	p_TBin := int(math.Floor(float64(nResP_TBins) * (result.P_T - minP_T) / (maxP_T - minP_T)))
+
+	for i, residual := range result.Residual {
+		r.residual[i].Fill(residual, 1)
+		if !math.IsNaN(result.Pull[i]) {
+			r.pull[i].Fill(result.Pull[i], 1)
+		}
+		if etaBin >= 0 && etaBin < nResEtaBins {
+			r.vsEta[i][etaBin].Fill(residual, 1)
+		}
+		if p_TBin >= 0 && p_TBin < nResP_TBins {
+			r.vsP_T[i][p_TBin].Fill(residual, 1)
+		}
 	}
-	return vector
 }
 
-func phiFromVector(vector [3]float64) float64 {
-	rho := math.Sqrt(vector[0]*vector[0] + vector[1]*vector[1])
-	if vector[0] >= 0 {
-		return math.Asin(vector[1] / rho)
+func (r *resolutionHists) merge(other *resolutionHists) {
+	for i := range r.residual {
+		ana.MergeH1D(r.residual[i], other.residual[i])
+		ana.MergeH1D(r.pull[i], other.pull[i])
+		ana.MergeH1Ds(r.vsEta[i][:], other.vsEta[i][:])
+		ana.MergeH1Ds(r.vsP_T[i][:], other.vsP_T[i][:])
+	}
+}
+
+// save adds the histograms to savedHists under set.  The resolution curves
+// are fitted when drawn, and so are not saved.
+func (r *resolutionHists) save(set string) {
+	for i, key := range trackParamKeys {
+		residualLabel := "reco - true " + trackParamNames[i] + trackParamUnits[i]
+		savedHists.Add(set+"/residual/"+key, r.residual[i], residualLabel, "count")
+		savedHists.Add(set+"/pull/"+key, r.pull[i], "(reco - true) / sigma", "count")
+		for j, h := range r.vsEta[i] {
+			savedHists.Add(fmt.Sprintf("%v/residualVsEta/%v/%d", set, key, j), h, residualLabel, "count")
+		}
+		for j, h := range r.vsP_T[i] {
+			savedHists.Add(fmt.Sprintf("%v/residualVsP_T/%v/%d", set, key, j), h, residualLabel, "count")
+		}
+	}
+}
+
+// newResolutionPages returns one page per track parameter, each with tiles
+// for the residual, the pull, and the resolution versus eta and p_T.
+func newResolutionPages() []*hplot.TiledPlot {
+	var pages []*hplot.TiledPlot
+	for i, name := range trackParamNames {
+		page := hplot.NewTiledPlot(draw.Tiles{
+			Rows: 2,
+			Cols: 2,
+			PadX: 5 * vg.Millimeter,
+			PadY: 5 * vg.Millimeter,
+		})
+
+		unit := trackParamUnits[i]
+		labels := [][3]string{
+			{name + " Residual", "reco - true " + name + unit, "count"},
+			{name + " Pull", "(reco - true) / sigma", "count"},
+			{name + " Resolution vs. eta", "lab eta", "sigma(" + name + ")" + unit},
+			{name + " Resolution vs. p_T", "lab p_T {GeV}", "sigma(" + name + ")" + unit},
+		}
+		for j, label := range labels {
+			plot := page.Plot(j%2, j/2)
+			*plot = *ana.NewPlot(label[0], label[1], label[2])
+		}
+
+		pages = append(pages, page)
+	}
+	return pages
+}
+
+// draw adds the distributions to the pages made by newResolutionPages, with
+// the resolutions taken from Gaussian fits to the residual cores.
+func (r *resolutionHists) draw(pages []*hplot.TiledPlot, style ana.LineStyle, label string) {
+	for i, page := range pages {
+		hResidual := hplot.NewH1D(r.residual[i])
+		style.Apply(hResidual)
+		page.Plot(0, 0).Add(hResidual)
+		page.Plot(0, 0).Legend.Add(label, hResidual)
+
+		hPull := hplot.NewH1D(r.pull[i])
+		style.Apply(hPull)
+		if !*inputsAreDirs {
+			hPull.Infos.Style = hplot.HInfoSummary
+		}
+		page.Plot(1, 0).Add(hPull)
+
+		vsEta := resolutionCurve(r.vsEta[i][:], minEta, maxEta)
+		if vsEta.Len() > 0 {
+			page.Plot(0, 1).Add(style.NewErrorPlot(vsEta))
+		}
+
+		vsP_T := resolutionCurve(r.vsP_T[i][:], minP_T, maxP_T)
+		if vsP_T.Len() > 0 {
+			page.Plot(1, 1).Add(style.NewErrorPlot(vsP_T))
+		}
 	}
-	return -math.Asin(vector[1]/rho) + math.Pi
 }
 
-func dotProduct(vector1 [3]float64, vector2 [3]float64) float64 {
-	return vector1[0]*vector2[0] + vector1[1]*vector2[1] + vector1[2]*vector2[2]
+// resolutionCurve returns the fitted core width of each of the residual
+// histograms in hists, which evenly divide the range from min to max.  Bins
+// with too few entries for a stable fit are omitted.
+func resolutionCurve(hists []*hbook.H1D, min, max float64) *hbook.S2D {
+	_, sigmas := ana.CoreCurves(hists, min, max, minFitEntries, fitCoreNSigma)
+	return sigmas
 }